@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================
+// AUTENTICACIÓN - VALIDACIÓN DE JWT
+// ============================================
+
+// TokenClaims contiene los claims verificados del token de acceso
+type TokenClaims struct {
+	Subject   string
+	Username  string
+	Role      string
+	Issuer    string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	Raw       map[string]interface{}
+}
+
+type contextKey string
+
+const (
+	claimsContextKey contextKey = "claims"
+	tokenContextKey  contextKey = "token"
+)
+
+var (
+	errMalformedToken    = errors.New("malformed token")
+	errUnsupportedAlg    = errors.New("unsupported token algorithm")
+	errInvalidSignature  = errors.New("invalid token signature")
+	errTokenExpired      = errors.New("token expired")
+	errMissingExpiry     = errors.New("token has no expiration")
+	errTokenNotYetValid  = errors.New("token not yet valid")
+	errTokenIssuedFuture = errors.New("token issued in the future")
+	errInvalidIssuer     = errors.New("invalid token issuer")
+	errInvalidAudience   = errors.New("invalid token audience")
+)
+
+// tokenErrorMessages es lo que ve el cliente para cada error de validación;
+// el detalle (qué claim falló y por qué) queda solo en el log
+var tokenErrorMessages = []struct {
+	err     error
+	message string
+}{
+	{errMalformedToken, "Malformed token"},
+	{errUnsupportedAlg, "Unsupported token algorithm"},
+	{errInvalidSignature, "Invalid token signature"},
+	{errTokenExpired, "Token expired"},
+	{errMissingExpiry, "Token has no expiration"},
+	{errTokenNotYetValid, "Token not yet valid"},
+	{errTokenIssuedFuture, "Token issued in the future"},
+	{errInvalidIssuer, "Invalid token issuer"},
+	{errInvalidAudience, "Invalid token audience"},
+}
+
+func tokenErrorMessage(err error) string {
+	for _, known := range tokenErrorMessages {
+		if errors.Is(err, known.err) {
+			return known.message
+		}
+	}
+	return "Invalid token"
+}
+
+// JWTValidator verifica tokens HS256 firmados con el secreto compartido
+type JWTValidator struct {
+	secret    []byte
+	issuer    string
+	audience  string
+	clockSkew time.Duration
+	now       func() time.Time
+}
+
+func NewJWTValidator(config *Config) *JWTValidator {
+	return &JWTValidator{
+		secret:    []byte(config.JWTSecret),
+		issuer:    config.JWTIssuer,
+		audience:  config.JWTAudience,
+		clockSkew: config.JWTClockSkew,
+		now:       time.Now,
+	}
+}
+
+// Validate comprueba firma, fechas, issuer y audience y devuelve los claims
+func (v *JWTValidator) Validate(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errMalformedToken
+	}
+	if header.Alg != "HS256" {
+		return nil, errUnsupportedAlg
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errInvalidSignature
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payloadJSON))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, errMalformedToken
+	}
+
+	claims, err := parseClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JWTValidator) validateClaims(claims *TokenClaims) error {
+	now := v.now()
+
+	// Un token sin exp valdría para siempre
+	if claims.ExpiresAt.IsZero() {
+		return errMissingExpiry
+	}
+	if now.After(claims.ExpiresAt.Add(v.clockSkew)) {
+		return errTokenExpired
+	}
+	if !claims.NotBefore.IsZero() && now.Before(claims.NotBefore.Add(-v.clockSkew)) {
+		return errTokenNotYetValid
+	}
+	if !claims.IssuedAt.IsZero() && now.Before(claims.IssuedAt.Add(-v.clockSkew)) {
+		return errTokenIssuedFuture
+	}
+
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return errInvalidIssuer
+	}
+	if v.audience != "" {
+		found := false
+		for _, aud := range claims.Audience {
+			if aud == v.audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errInvalidAudience
+		}
+	}
+	return nil
+}
+
+func parseClaims(raw map[string]interface{}) (*TokenClaims, error) {
+	claims := &TokenClaims{Raw: raw}
+
+	claims.Subject = stringClaim(raw, "sub")
+	claims.Username = stringClaim(raw, "username")
+	claims.Role = stringClaim(raw, "role")
+	claims.Issuer = stringClaim(raw, "iss")
+
+	switch aud := raw["aud"].(type) {
+	case string:
+		claims.Audience = []string{aud}
+	case []interface{}:
+		for _, item := range aud {
+			if s, ok := item.(string); ok {
+				claims.Audience = append(claims.Audience, s)
+			}
+		}
+	}
+
+	var err error
+	if claims.ExpiresAt, err = timeClaim(raw, "exp"); err != nil {
+		return nil, err
+	}
+	if claims.NotBefore, err = timeClaim(raw, "nbf"); err != nil {
+		return nil, err
+	}
+	if claims.IssuedAt, err = timeClaim(raw, "iat"); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func stringClaim(raw map[string]interface{}, name string) string {
+	switch value := raw[name].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	}
+	return ""
+}
+
+func timeClaim(raw map[string]interface{}, name string) (time.Time, error) {
+	value, exists := raw[name]
+	if !exists || value == nil {
+		return time.Time{}, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: %s is not numeric", errMalformedToken, name)
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s is not numeric", errMalformedToken, name)
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), nil
+}
+
+// ============================================
+// MIDDLEWARE - AUTENTICACIÓN
+// ============================================
+
+// requireAuth valida el token Bearer y deja los claims en el contexto
+func (g *Gateway) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			writeJSONError(w, http.StatusUnauthorized, "Authorization header required")
+			return
+		}
+
+		token, ok := bearerToken(authHeader)
+		if !ok {
+			writeJSONError(w, http.StatusUnauthorized, "Invalid authorization scheme")
+			return
+		}
+
+		r, claims, err := g.validateRequestToken(r, token)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Rejected token", "method", r.Method, "path", r.URL.Path, "error", err)
+			writeJSONError(w, http.StatusUnauthorized, tokenErrorMessage(err))
+			return
+		}
+
+		setLogUser(r.Context(), claims.Subject)
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// validatedToken es el resultado de validar el token de una petición
+type validatedToken struct {
+	token  string
+	claims *TokenClaims
+	err    error
+}
+
+// validateRequestToken valida el token una sola vez por petición: el primer
+// middleware que lo necesita (rate limit o requireAuth) deja el resultado en
+// el contexto y los siguientes lo reutilizan en vez de verificar de nuevo la
+// firma
+func (g *Gateway) validateRequestToken(r *http.Request, token string) (*http.Request, *TokenClaims, error) {
+	if validated, ok := r.Context().Value(tokenContextKey).(*validatedToken); ok && validated.token == token {
+		return r, validated.claims, validated.err
+	}
+	claims, err := g.jwtValidator.Validate(token)
+	ctx := context.WithValue(r.Context(), tokenContextKey, &validatedToken{token: token, claims: claims, err: err})
+	return r.WithContext(ctx), claims, err
+}
+
+func bearerToken(authHeader string) (string, bool) {
+	const prefix = "bearer "
+	if len(authHeader) <= len(prefix) || !strings.EqualFold(authHeader[:len(prefix)], prefix) {
+		return "", false
+	}
+	token := strings.TrimSpace(authHeader[len(prefix):])
+	return token, token != ""
+}
+
+// claimsFromContext obtiene los claims verificados por requireAuth
+func claimsFromContext(ctx context.Context) (*TokenClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*TokenClaims)
+	return claims, ok
+}
+
+// ============================================
+// AUTORIZACIÓN - PROPIEDAD DEL RECURSO
+// ============================================
+
+const adminRole = "admin"
+
+// IsAdmin indica si el token pertenece a un administrador
+func (c *TokenClaims) IsAdmin() bool {
+	return strings.EqualFold(c.Role, adminRole)
+}
+
+// Owns indica si el token corresponde al usuario indicado (por sub o username)
+func (c *TokenClaims) Owns(username string) bool {
+	if username == "" {
+		return false
+	}
+	return c.Subject == username || c.Username == username
+}
+
+// authorizeUserAccess responde 403 si el llamante no es el dueño ni admin
+func (g *Gateway) authorizeUserAccess(w http.ResponseWriter, r *http.Request, username string) bool {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "Authorization header required")
+		return false
+	}
+
+	if claims.Owns(username) || claims.IsAdmin() {
+		return true
+	}
+
+	slog.WarnContext(r.Context(), "Forbidden access to another user", "method", r.Method, "username", username)
+	writeJSONError(w, http.StatusForbidden, "You are not allowed to act on this user")
+	return false
+}
+
+// requireAdmin exige un token válido con rol admin
+func (g *Gateway) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return g.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := claimsFromContext(r.Context())
+		if !claims.IsAdmin() {
+			writeJSONError(w, http.StatusForbidden, "Admin role required")
+			return
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret"
+
+func signTestToken(t *testing.T, secret string, claims map[string]interface{}) string {
+	t.Helper()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payloadJSON, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshal claims: %v", err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(payloadJSON)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(header + "." + payload))
+	return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newTestValidator(now time.Time) *JWTValidator {
+	v := NewJWTValidator(&Config{
+		JWTSecret:    testSecret,
+		JWTIssuer:    "auth-service",
+		JWTAudience:  "api-gateway",
+		JWTClockSkew: 30 * time.Second,
+	})
+	v.now = func() time.Time { return now }
+	return v
+}
+
+func TestJWTValidatorValidate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	base := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub":      "42",
+			"username": "alice",
+			"role":     "user",
+			"iss":      "auth-service",
+			"aud":      []string{"api-gateway"},
+			"iat":      now.Add(-time.Minute).Unix(),
+			"nbf":      now.Add(-time.Minute).Unix(),
+			"exp":      now.Add(time.Hour).Unix(),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		secret  string
+		mutate  func(map[string]interface{})
+		wantErr error
+	}{
+		{name: "valid", secret: testSecret},
+		{name: "wrong secret", secret: "other", wantErr: errInvalidSignature},
+		{name: "expired", secret: testSecret, mutate: func(c map[string]interface{}) {
+			c["exp"] = now.Add(-time.Minute).Unix()
+		}, wantErr: errTokenExpired},
+		{name: "missing exp", secret: testSecret, mutate: func(c map[string]interface{}) {
+			delete(c, "exp")
+		}, wantErr: errMissingExpiry},
+		{name: "expired within skew", secret: testSecret, mutate: func(c map[string]interface{}) {
+			c["exp"] = now.Add(-10 * time.Second).Unix()
+		}},
+		{name: "not yet valid", secret: testSecret, mutate: func(c map[string]interface{}) {
+			c["nbf"] = now.Add(time.Minute).Unix()
+		}, wantErr: errTokenNotYetValid},
+		{name: "issued in the future", secret: testSecret, mutate: func(c map[string]interface{}) {
+			c["iat"] = now.Add(time.Minute).Unix()
+		}, wantErr: errTokenIssuedFuture},
+		{name: "wrong issuer", secret: testSecret, mutate: func(c map[string]interface{}) {
+			c["iss"] = "someone-else"
+		}, wantErr: errInvalidIssuer},
+		{name: "wrong audience", secret: testSecret, mutate: func(c map[string]interface{}) {
+			c["aud"] = "profiles"
+		}, wantErr: errInvalidAudience},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := base()
+			if tt.mutate != nil {
+				tt.mutate(claims)
+			}
+			token := signTestToken(t, tt.secret, claims)
+
+			got, err := newTestValidator(now).Validate(token)
+			if err != tt.wantErr {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (got.Subject != "42" || got.Username != "alice") {
+				t.Fatalf("unexpected claims: %+v", got)
+			}
+		})
+	}
+}
+
+func TestJWTValidatorRejectsGarbage(t *testing.T) {
+	v := newTestValidator(time.Now())
+	for _, token := range []string{"", "invalid.token.here", "a.b", "expired-token-123"} {
+		if _, err := v.Validate(token); err == nil {
+			t.Errorf("Validate(%q) succeeded, want error", token)
+		}
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	g := NewGateway(&Config{JWTSecret: testSecret, JWTClockSkew: time.Second})
+	var gotClaims *TokenClaims
+	handler := g.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		gotClaims, _ = claimsFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	t.Run("missing header", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/api/v1/profiles/me", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want 401", rec.Code)
+		}
+		var body map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] != "Authorization header required" {
+			t.Fatalf("unexpected body %q", rec.Body.String())
+		}
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/v1/profiles/me", nil)
+		req.Header.Set("Authorization", "Bearer invalid.token.here")
+		handler(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want 401", rec.Code)
+		}
+	})
+
+	t.Run("client message", func(t *testing.T) {
+		// El detalle del error (qué claim no es numérico) no llega al cliente
+		for message, claims := range map[string]map[string]interface{}{
+			"Token expired":   {"sub": "alice", "exp": time.Now().Add(-time.Hour).Unix()},
+			"Malformed token": {"sub": "alice", "exp": "tomorrow"},
+		} {
+			req := httptest.NewRequest("GET", "/api/v1/profiles/me", nil)
+			req.Header.Set("Authorization", "Bearer "+signTestToken(t, testSecret, claims))
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] != message {
+				t.Errorf("body = %s, want error %q", rec.Body.String(), message)
+			}
+		}
+	})
+
+	t.Run("valid token", func(t *testing.T) {
+		token := signTestToken(t, testSecret, map[string]interface{}{
+			"sub": "alice",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/v1/profiles/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		handler(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("status = %d, want 204", rec.Code)
+		}
+		if gotClaims == nil || gotClaims.Subject != "alice" {
+			t.Fatalf("claims not propagated: %+v", gotClaims)
+		}
+	})
+}
+
+func TestOwnershipEnforcement(t *testing.T) {
+	var upstreamCalls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstreamCalls, 1)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message":"ok"}`))
+	}))
+	defer upstream.Close()
+
+	g := NewGateway(&Config{
+		JWTSecret:         testSecret,
+		AuthServiceURL:    upstream.URL,
+		ProfileServiceURL: upstream.URL,
+		OrchestratorURL:   upstream.URL,
+	})
+	router := g.setupRoutes()
+
+	tests := []struct {
+		name       string
+		claims     map[string]interface{}
+		target     string
+		wantStatus int
+		wantCalls  int32
+	}{
+		{
+			name:       "other user is forbidden",
+			claims:     map[string]interface{}{"sub": "mallory", "role": "user"},
+			target:     "bob",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "owner by subject",
+			claims:     map[string]interface{}{"sub": "bob", "role": "user"},
+			target:     "bob",
+			wantStatus: http.StatusOK,
+			wantCalls:  1,
+		},
+		{
+			name:       "owner by username claim",
+			claims:     map[string]interface{}{"sub": "7", "username": "bob"},
+			target:     "bob",
+			wantStatus: http.StatusOK,
+			wantCalls:  1,
+		},
+		{
+			name:       "admin can act on others",
+			claims:     map[string]interface{}{"sub": "root", "role": "admin"},
+			target:     "bob",
+			wantStatus: http.StatusOK,
+			wantCalls:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&upstreamCalls, 0)
+			tt.claims["exp"] = time.Now().Add(time.Hour).Unix()
+			req := httptest.NewRequest("DELETE", "/api/v1/users/"+tt.target, nil)
+			req.Header.Set("Authorization", "Bearer "+signTestToken(t, testSecret, tt.claims))
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			calls := atomic.LoadInt32(&upstreamCalls)
+			if calls < tt.wantCalls || (tt.wantCalls == 0 && calls != 0) {
+				t.Fatalf("upstream calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+
+	t.Run("patch on other user is forbidden", func(t *testing.T) {
+		atomic.StoreInt32(&upstreamCalls, 0)
+		token := signTestToken(t, testSecret, map[string]interface{}{"sub": "mallory", "exp": time.Now().Add(time.Hour).Unix()})
+		req := httptest.NewRequest("PATCH", "/api/v1/users/bob/profile", strings.NewReader(`{"bio":"pwned"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		if calls := atomic.LoadInt32(&upstreamCalls); rec.Code != http.StatusForbidden || calls != 0 {
+			t.Fatalf("status = %d, upstream calls = %d; want 403 and no calls", rec.Code, calls)
+		}
+	})
+}
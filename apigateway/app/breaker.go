@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ============================================
+// CIRCUIT BREAKER POR UPSTREAM
+// ============================================
+
+var errCircuitOpen = errors.New("circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// BreakerSettings son los umbrales de un circuit breaker
+type BreakerSettings struct {
+	Window         int           // últimas llamadas que se tienen en cuenta
+	MinRequests    int           // llamadas mínimas en la ventana antes de poder abrir
+	FailureRate    float64       // proporción de fallos (0-1) que abre el circuito
+	OpenTimeout    time.Duration // tiempo abierto antes de dejar pasar pruebas
+	HalfOpenProbes int           // pruebas exitosas seguidas para volver a cerrar
+}
+
+// withDefaults completa los valores que no se configuraron
+func (s BreakerSettings) withDefaults() BreakerSettings {
+	if s.Window <= 0 {
+		s.Window = 20
+	}
+	if s.MinRequests <= 0 {
+		s.MinRequests = 10
+	}
+	if s.MinRequests > s.Window {
+		s.MinRequests = s.Window
+	}
+	if s.FailureRate <= 0 || s.FailureRate > 1 {
+		s.FailureRate = 0.5
+	}
+	if s.OpenTimeout <= 0 {
+		s.OpenTimeout = 30 * time.Second
+	}
+	if s.HalfOpenProbes <= 0 {
+		s.HalfOpenProbes = 1
+	}
+	return s
+}
+
+// CircuitBreaker corta las llamadas a un upstream que está fallando. En
+// closed cuenta fallos en una ventana de las últimas llamadas; al superar la
+// tasa de fallos pasa a open y rechaza todo durante OpenTimeout. Después pasa
+// a half-open y deja pasar de a una llamada de prueba: si fallan vuelve a
+// open, si HalfOpenProbes salen bien vuelve a closed.
+type CircuitBreaker struct {
+	name     string
+	settings BreakerSettings
+	onChange func(name string, from, to breakerState)
+	now      func() time.Time
+
+	mu         sync.Mutex
+	state      breakerState
+	generation uint64 // cambia en cada transición; descarta resultados viejos
+	results    []bool // ventana circular, true = fallo
+	next       int
+	count      int
+	failures   int
+	openedAt   time.Time
+	probing    bool
+	successes  int
+}
+
+func NewCircuitBreaker(name string, settings BreakerSettings, onChange func(name string, from, to breakerState)) *CircuitBreaker {
+	settings = settings.withDefaults()
+	return &CircuitBreaker{
+		name:     name,
+		settings: settings,
+		onChange: onChange,
+		now:      time.Now,
+		results:  make([]bool, settings.Window),
+	}
+}
+
+// Allow indica si se puede llamar al upstream. Devuelve la generación que hay
+// que pasarle a Record con el resultado de la llamada.
+func (b *CircuitBreaker) Allow() (uint64, error) {
+	if b == nil {
+		return 0, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.settings.OpenTimeout {
+			return 0, errCircuitOpen
+		}
+		b.transition(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return 0, errCircuitOpen
+		}
+		b.probing = true
+	}
+	return b.generation, nil
+}
+
+// Record registra el resultado de una llamada autorizada por Allow. ok es nil
+// si la llamada no cuenta (p. ej. la canceló el cliente).
+func (b *CircuitBreaker) Record(generation uint64, ok *bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case breakerHalfOpen:
+		b.probing = false
+		switch {
+		case ok == nil:
+		case !*ok:
+			b.transition(breakerOpen)
+		default:
+			b.successes++
+			if b.successes >= b.settings.HalfOpenProbes {
+				b.transition(breakerClosed)
+			}
+		}
+
+	case breakerClosed:
+		if ok == nil {
+			return
+		}
+		failed := !*ok
+		if b.count == len(b.results) {
+			if b.results[b.next] {
+				b.failures--
+			}
+		} else {
+			b.count++
+		}
+		b.results[b.next] = failed
+		b.next = (b.next + 1) % len(b.results)
+		if failed {
+			b.failures++
+		}
+		if b.count >= b.settings.MinRequests && float64(b.failures)/float64(b.count) >= b.settings.FailureRate {
+			b.transition(breakerOpen)
+		}
+	}
+}
+
+// transition cambia de estado y limpia los contadores; se llama con mu tomado
+func (b *CircuitBreaker) transition(to breakerState) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.probing = false
+	b.successes = 0
+	if to == breakerOpen {
+		b.openedAt = b.now()
+	}
+	if to == breakerClosed {
+		b.count, b.next, b.failures = 0, 0, 0
+	}
+	if b.onChange != nil {
+		b.onChange(b.name, from, to)
+	}
+}
+
+// State devuelve el estado actual. Un circuito open cuyo cool-down ya venció
+// se informa como half-open, que es lo que verá la próxima llamada.
+func (b *CircuitBreaker) State() breakerState {
+	if b == nil {
+		return breakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		return breakerHalfOpen
+	}
+	return b.state
+}
+
+// callOutcome decide si una llamada cuenta como éxito o fallo para el
+// breaker: los errores de red y los 5xx son fallos; una cancelación (el
+// cliente se fue o el resultado ya no hacía falta) no cuenta.
+func callOutcome(status int, err error) *bool {
+	if err != nil && upstreamCanceled(err) {
+		return nil
+	}
+	ok := err == nil && status < http.StatusInternalServerError
+	return &ok
+}
+
+// newBreakers crea un breaker por cada upstream conocido
+func (g *Gateway) newBreakers(settings BreakerSettings) map[string]*CircuitBreaker {
+	onChange := func(name string, from, to breakerState) {
+		slog.Warn("Circuit breaker state changed", "upstream", name, "from", from.String(), "to", to.String())
+		g.metrics.observeBreaker(name, from, to)
+	}
+	breakers := make(map[string]*CircuitBreaker, len(knownUpstreams))
+	for name := range knownUpstreams {
+		breakers[name] = NewCircuitBreaker(name, settings, onChange)
+		g.metrics.breakerState.WithLabelValues(name).Set(float64(breakerClosed))
+	}
+	return breakers
+}
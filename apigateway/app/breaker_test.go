@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker("profiles", BreakerSettings{Window: 4, MinRequests: 4, FailureRate: 0.5, OpenTimeout: time.Minute}, nil)
+	b.now = func() time.Time { return now }
+
+	record := func(ok bool) {
+		t.Helper()
+		gen, err := b.Allow()
+		if err != nil {
+			t.Fatalf("Allow() = %v in state %s", err, b.State())
+		}
+		b.Record(gen, &ok)
+	}
+
+	record(true)
+	record(false)
+	record(true)
+	if b.State() != breakerClosed {
+		t.Fatalf("opened before MinRequests: %s", b.State())
+	}
+	record(false)
+	if b.State() != breakerOpen {
+		t.Fatalf("state = %s, want open at 50%% failures", b.State())
+	}
+	if _, err := b.Allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("Allow() while open = %v", err)
+	}
+
+	// Tras el cool-down pasa una sola prueba
+	now = now.Add(time.Minute)
+	gen, err := b.Allow()
+	if err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	if _, err := b.Allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatal("second concurrent probe allowed")
+	}
+	failed := false
+	b.Record(gen, &failed)
+	if b.State() != breakerOpen {
+		t.Fatalf("failed probe left state %s", b.State())
+	}
+
+	now = now.Add(time.Minute)
+	record(true)
+	if b.State() != breakerClosed {
+		t.Fatalf("successful probe left state %s", b.State())
+	}
+}
+
+func TestOpenBreakerFailsFastForOptionalProfiles(t *testing.T) {
+	var profileCalls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/profiles/") {
+			atomic.AddInt32(&profileCalls, 1)
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"user":{"username":"bob"}}`))
+	}))
+	defer upstream.Close()
+
+	compositions, err := LoadCompositions("../config/compositions.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGateway(&Config{
+		JWTSecret:         testSecret,
+		AuthServiceURL:    upstream.URL,
+		ProfileServiceURL: upstream.URL,
+		Breaker:           BreakerSettings{Window: 2, MinRequests: 2, OpenTimeout: time.Hour},
+	})
+	g.compositions = compositions
+	router := g.setupRoutes()
+	token := signTestToken(t, testSecret, map[string]interface{}{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest("GET", "/api/v1/users/bob/profile", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want degraded 200", i, rec.Code)
+		}
+	}
+
+	if got := atomic.LoadInt32(&profileCalls); got != 2 {
+		t.Fatalf("profiles called %d times, want 2 before the breaker opened", got)
+	}
+	if status := g.upstreamStatus("profiles"); status["circuit"] != "open" {
+		t.Fatalf("upstreamStatus() = %v", status)
+	}
+	if status := g.upstreamStatus("auth"); status["circuit"] != "closed" {
+		t.Fatalf("upstreamStatus() = %v", status)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+// ============================================
+// COMPOSICIÓN DE RESPUESTAS
+// ============================================
+
+// unifiedUserComposition es la composición que atiende GET /users/{username}/profile
+const unifiedUserComposition = "unified-user"
+
+// CompositionCall es una llamada a un upstream dentro de una composición
+type CompositionCall struct {
+	Name     string `yaml:"name"`
+	Upstream string `yaml:"upstream"`
+	Path     string `yaml:"path"`
+	Required bool   `yaml:"required"`
+}
+
+// FieldMapping copia un valor de la respuesta de una llamada al documento
+// final. From empieza con el nombre de la llamada (profile.github_url) y To
+// es la ruta en el documento (user.githubUrl).
+type FieldMapping struct {
+	From      string `yaml:"from"`
+	To        string `yaml:"to"`
+	Transform string `yaml:"transform"`
+}
+
+// Composition describe una respuesta armada a partir de varios upstreams. El
+// documento parte del body de la llamada Base y se le aplican los Fields.
+type Composition struct {
+	Name   string            `yaml:"name"`
+	Base   string            `yaml:"base"`
+	Calls  []CompositionCall `yaml:"calls"`
+	Fields []FieldMapping    `yaml:"fields"`
+
+	// Timeout es el tiempo máximo para todas las llamadas (por defecto 10s)
+	Timeout     time.Duration `yaml:"-"`
+	TimeoutSpec string        `yaml:"timeout"`
+}
+
+// fieldTransforms son las transformaciones que se pueden pedir en un campo.
+// Los valores que no son string pasan sin cambios.
+var fieldTransforms = map[string]func(interface{}) interface{}{
+	"lowercase": func(v interface{}) interface{} { return mapString(v, strings.ToLower) },
+	"uppercase": func(v interface{}) interface{} { return mapString(v, strings.ToUpper) },
+	"trim":      func(v interface{}) interface{} { return mapString(v, strings.TrimSpace) },
+	"null_if_empty": func(v interface{}) interface{} {
+		if s, ok := v.(string); ok && strings.TrimSpace(s) == "" {
+			return nil
+		}
+		return v
+	},
+}
+
+func mapString(v interface{}, fn func(string) string) interface{} {
+	if s, ok := v.(string); ok {
+		return fn(s)
+	}
+	return v
+}
+
+// LoadCompositions lee y valida el archivo de composiciones (YAML o JSON)
+func LoadCompositions(path string) (map[string]*Composition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading compositions %s: %w", path, err)
+	}
+	return parseCompositions(data)
+}
+
+func parseCompositions(data []byte) (map[string]*Composition, error) {
+	var raw struct {
+		Compositions []*Composition `yaml:"compositions"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing compositions: %w", err)
+	}
+
+	compositions := make(map[string]*Composition, len(raw.Compositions))
+	for i, composition := range raw.Compositions {
+		if err := composition.validate(); err != nil {
+			return nil, fmt.Errorf("composition %d (%s): %w", i, composition.Name, err)
+		}
+		if _, dup := compositions[composition.Name]; dup {
+			return nil, fmt.Errorf("composition %d: duplicate name %q", i, composition.Name)
+		}
+		compositions[composition.Name] = composition
+	}
+	return compositions, nil
+}
+
+func (c *Composition) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(c.Calls) == 0 {
+		return fmt.Errorf("at least one call is required")
+	}
+
+	c.Timeout = defaultRouteTimeout
+	if c.TimeoutSpec != "" {
+		timeout, err := time.ParseDuration(c.TimeoutSpec)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", c.TimeoutSpec)
+		}
+		c.Timeout = timeout
+	}
+
+	calls := map[string]CompositionCall{}
+	for _, call := range c.Calls {
+		if call.Name == "" {
+			return fmt.Errorf("call name is required")
+		}
+		if _, dup := calls[call.Name]; dup {
+			return fmt.Errorf("duplicate call %q", call.Name)
+		}
+		if _, ok := knownUpstreams[call.Upstream]; !ok {
+			return fmt.Errorf("call %s: unknown upstream %q", call.Name, call.Upstream)
+		}
+		if !strings.HasPrefix(call.Path, "/") {
+			return fmt.Errorf("call %s: path must start with /", call.Name)
+		}
+		calls[call.Name] = call
+	}
+
+	if c.Base != "" {
+		base, ok := calls[c.Base]
+		if !ok {
+			return fmt.Errorf("base %q is not a call", c.Base)
+		}
+		if !base.Required {
+			return fmt.Errorf("base call %q must be required", c.Base)
+		}
+	}
+
+	for _, field := range c.Fields {
+		source := strings.SplitN(field.From, ".", 2)
+		if len(source) != 2 || source[1] == "" {
+			return fmt.Errorf("field from %q must be <call>.<path>", field.From)
+		}
+		if _, ok := calls[source[0]]; !ok {
+			return fmt.Errorf("field from %q references unknown call %q", field.From, source[0])
+		}
+		if field.To == "" {
+			return fmt.Errorf("field from %q has no target", field.From)
+		}
+		if _, ok := fieldTransforms[field.Transform]; field.Transform != "" && !ok {
+			return fmt.Errorf("field %s: unknown transform %q", field.To, field.Transform)
+		}
+	}
+	return nil
+}
+
+// lookupPath busca un valor por ruta con puntos (user.profile.bio)
+func lookupPath(doc map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// setPath escribe value en la ruta, creando los objetos intermedios que
+// falten. Si un intermedio existe pero no es un objeto no se escribe nada.
+func setPath(doc map[string]interface{}, path string, value interface{}) bool {
+	keys := strings.Split(path, ".")
+	current := doc
+	for _, key := range keys[:len(keys)-1] {
+		next, exists := current[key]
+		if !exists || next == nil {
+			child := map[string]interface{}{}
+			current[key] = child
+			current = child
+			continue
+		}
+		obj, ok := next.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		current = obj
+	}
+	current[keys[len(keys)-1]] = value
+	return true
+}
+
+// ============================================
+// HANDLER - COMPOSICIÓN
+// ============================================
+
+// compose ejecuta las llamadas de la composición en paralelo y arma la
+// respuesta. Si una llamada requerida falla se responde con su error; las
+// opcionales que fallan solo dejan sus campos fuera.
+func (g *Gateway) compose(w http.ResponseWriter, r *http.Request, composition *Composition) {
+	vars := mux.Vars(r)
+
+	// Las URLs se arman antes de llamar a nadie: un parámetro inválido es 400
+	targets := make([]string, len(composition.Calls))
+	for i, call := range composition.Calls {
+		targetURL, err := g.upstreamTarget(call.Upstream, call.Path, vars, nil)
+		if err != nil {
+			writeUpstreamTargetError(w, r, err)
+			return
+		}
+		targets[i] = targetURL
+	}
+
+	// Si una llamada requerida falla la respuesta ya está decidida: las demás
+	// se cancelan con errCallNotNeeded en vez de esperarlas
+	timeout := composition.Timeout
+	if timeout <= 0 {
+		timeout = defaultRouteTimeout
+	}
+	ctx, cancelTimeout := context.WithTimeout(r.Context(), timeout)
+	defer cancelTimeout()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	callReq := r.WithContext(ctx)
+
+	// Las llamadas son lecturas aunque la composición cierre un PATCH o PUT:
+	// van siempre como GET y sin los headers del body original
+	header := http.Header{}
+	copyRequestHeaders(header, r.Header)
+	header.Del("Content-Type")
+
+	responses := make([]*ServiceResponse, len(composition.Calls))
+	var wg sync.WaitGroup
+	for i, call := range composition.Calls {
+		wg.Add(1)
+		go func(i int, call CompositionCall) {
+			defer wg.Done()
+			resp := g.bufferedRequest(call.Upstream, callReq, http.MethodGet, targets[i], header.Clone(), nil)
+			if call.Required && (resp.Error != nil || resp.StatusCode != http.StatusOK) && !upstreamCanceled(resp.Error) {
+				cancel(errCallNotNeeded)
+			}
+			responses[i] = resp
+		}(i, call)
+	}
+	wg.Wait()
+	setAttemptsHeader(w, responses...)
+	notNeeded := errors.Is(context.Cause(ctx), errCallNotNeeded)
+
+	bodies := map[string]map[string]interface{}{}
+	for i, call := range composition.Calls {
+		resp := responses[i]
+
+		if resp.Error != nil {
+			// Cancelada por nosotros: la llamada que falló da la respuesta
+			if notNeeded && upstreamCanceled(resp.Error) {
+				continue
+			}
+			if !upstreamCanceled(resp.Error) {
+				slog.ErrorContext(r.Context(), "Composition call failed", "composition", composition.Name, "call", call.Name, "upstream", call.Upstream, "error", resp.Error)
+			}
+			if call.Required {
+				writeUpstreamError(w, resp.Error)
+				return
+			}
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			if call.Required {
+				w.WriteHeader(resp.StatusCode)
+				w.Write(resp.Body)
+				return
+			}
+			slog.WarnContext(r.Context(), "Composition data not available", "composition", composition.Name, "call", call.Name, "upstream", call.Upstream, "status", resp.StatusCode)
+			continue
+		}
+
+		var data map[string]interface{}
+		if err := json.Unmarshal(resp.Body, &data); err != nil {
+			slog.ErrorContext(r.Context(), "Error parsing composition response", "composition", composition.Name, "call", call.Name, "upstream", call.Upstream, "error", err)
+			if call.Required {
+				http.Error(w, "Error processing response", http.StatusInternalServerError)
+				return
+			}
+			continue
+		}
+		bodies[call.Name] = data
+	}
+
+	// Un body base null se decodifica como mapa nil: se parte de un documento
+	// vacío
+	document := map[string]interface{}{}
+	if base := bodies[composition.Base]; base != nil {
+		document = base
+	}
+
+	for _, field := range composition.Fields {
+		source := strings.SplitN(field.From, ".", 2)
+		data, ok := bodies[source[0]]
+		if !ok {
+			continue
+		}
+		value, ok := lookupPath(data, source[1])
+		if !ok {
+			continue
+		}
+		if field.Transform != "" {
+			value = fieldTransforms[field.Transform](value)
+		}
+		if !setPath(document, field.To, value) {
+			slog.WarnContext(r.Context(), "Could not set composition field", "composition", composition.Name, "field", field.To)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(document)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLoadCompositionsDefaultFile(t *testing.T) {
+	compositions, err := LoadCompositions("../config/compositions.yaml")
+	if err != nil {
+		t.Fatalf("LoadCompositions() error = %v", err)
+	}
+	if _, ok := compositions[unifiedUserComposition]; !ok {
+		t.Fatalf("default file has no %s composition", unifiedUserComposition)
+	}
+}
+
+func TestParseCompositionsValidation(t *testing.T) {
+	invalid := map[string]string{
+		"unknown upstream":  "compositions:\n  - {name: x, calls: [{name: a, upstream: billing, path: /a}]}\n",
+		"optional base":     "compositions:\n  - {name: x, base: a, calls: [{name: a, upstream: auth, path: /a}]}\n",
+		"unknown call":      "compositions:\n  - {name: x, calls: [{name: a, upstream: auth, path: /a}], fields: [{from: b.x, to: x}]}\n",
+		"unknown transform": "compositions:\n  - {name: x, calls: [{name: a, upstream: auth, path: /a}], fields: [{from: a.x, to: x, transform: rot13}]}\n",
+		"invalid timeout":   "compositions:\n  - {name: x, timeout: soon, calls: [{name: a, upstream: auth, path: /a}]}\n",
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseCompositions([]byte(data)); err == nil {
+				t.Fatal("expected validation error")
+			}
+		})
+	}
+}
+
+func TestUnifiedUserComposition(t *testing.T) {
+	profilesUp := true
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/accounts/bob":
+			json.NewEncoder(w).Encode(map[string]interface{}{"user": map[string]interface{}{"username": "bob"}})
+		case "/profiles/bob":
+			if !profilesUp {
+				http.Error(w, "down", http.StatusServiceUnavailable)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"bio":          "hi",
+				"facebook_url": "https://facebook.com/bob",
+				"website_url":  "https://bob.dev",
+				"user_id":      42,
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	compositions, err := LoadCompositions("../config/compositions.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGateway(&Config{JWTSecret: testSecret, AuthServiceURL: upstream.URL, ProfileServiceURL: upstream.URL})
+	g.compositions = compositions
+	router := g.setupRoutes()
+	token := signTestToken(t, testSecret, map[string]interface{}{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+
+	get := func() map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/users/bob/profile", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+		}
+		var body struct {
+			User map[string]interface{} `json:"user"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body.User
+	}
+
+	user := get()
+	if user["bio"] != "hi" || user["facebookUrl"] != "https://facebook.com/bob" || user["websiteUrl"] != "https://bob.dev" {
+		t.Fatalf("profile fields not merged: %v", user)
+	}
+	if _, leaked := user["user_id"]; leaked {
+		t.Fatalf("unmapped field leaked into response: %v", user)
+	}
+
+	// profiles es opcional: sin él se devuelve solo la parte de auth
+	profilesUp = false
+	user = get()
+	if user["username"] != "bob" || user["bio"] != nil {
+		t.Fatalf("unexpected user without profiles: %v", user)
+	}
+}
+
+func TestCompositionNullBaseBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/accounts/bob":
+			w.Write([]byte("null"))
+		case "/profiles/bob":
+			w.Write([]byte(`{"bio": "hi"}`))
+		}
+	}))
+	defer upstream.Close()
+
+	compositions, err := LoadCompositions("../config/compositions.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGateway(&Config{AuthServiceURL: upstream.URL, ProfileServiceURL: upstream.URL})
+
+	rec := httptest.NewRecorder()
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/", nil), map[string]string{"username": "bob"})
+	g.compose(rec, req, compositions[unifiedUserComposition])
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"user":{"bio":"hi"}}`+"\n" {
+		t.Fatalf("response = %d %s, want only the profile fields", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCompositionCancelsCallsNotNeeded(t *testing.T) {
+	budget := make(chan string, 1)
+	cancelled := make(chan struct{}, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/accounts/bob":
+			time.Sleep(20 * time.Millisecond)
+			http.NotFound(w, r)
+		case "/profiles/bob":
+			select {
+			case budget <- r.Header.Get(requestBudgetHeader):
+			default:
+			}
+			<-r.Context().Done()
+			cancelled <- struct{}{}
+		}
+	}))
+	defer upstream.Close()
+
+	compositions, err := parseCompositions([]byte("compositions:\n" +
+		"  - name: x\n    timeout: 2s\n    calls:\n" +
+		"      - {name: auth, upstream: auth, path: \"/accounts/{username}\", required: true}\n" +
+		"      - {name: profile, upstream: profiles, path: \"/profiles/{username}\"}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGateway(&Config{
+		AuthServiceURL:    upstream.URL,
+		ProfileServiceURL: upstream.URL,
+		Breaker:           BreakerSettings{Window: 4, MinRequests: 4, FailureRate: 0.5, OpenTimeout: time.Minute, HalfOpenProbes: 1},
+	})
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/", nil), map[string]string{"username": "bob"})
+	g.compose(rec, req, compositions["x"])
+
+	// La respuesta es la de la llamada requerida, sin esperar a profiles
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want the required call's 404", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("compose took %v, want the optional call cancelled", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("optional call was not cancelled upstream")
+	}
+	if ms, err := strconv.Atoi(<-budget); err != nil || ms <= 0 || ms > 2000 {
+		t.Errorf("%s = %d (%v), want the remaining composition budget", requestBudgetHeader, ms, err)
+	}
+	if got := testutil.ToFloat64(g.metrics.upstreamCanceled.WithLabelValues("profiles", "not_needed")); got != 1 {
+		t.Errorf("not_needed cancellations = %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(g.metrics.upstreamErrors); got != 0 {
+		t.Errorf("cancelled call counted as %d upstream errors", got)
+	}
+
+	// Las llamadas descartadas no son fallas de profiles: su circuito no se
+	// abre aunque se repitan
+	for i := 0; i < 5; i++ {
+		g.compose(httptest.NewRecorder(), req, compositions["x"])
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("optional call was not cancelled upstream")
+		}
+	}
+	if state := g.breakers["profiles"].State(); state != breakerClosed {
+		t.Errorf("profiles breaker = %s after not_needed cancellations, want closed", state)
+	}
+	if got := testutil.ToFloat64(g.metrics.upstreamCanceled.WithLabelValues("profiles", "not_needed")); got != 6 {
+		t.Errorf("not_needed cancellations = %v, want 6", got)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================
+// CONSUL - CLIENTE HTTP
+// ============================================
+
+// ConsulClient habla con la API HTTP de Consul
+type ConsulClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewConsulClient(baseURL string) *ConsulClient {
+	return &ConsulClient{
+		baseURL: baseURL,
+		// Sin timeout global: las consultas bloqueantes pueden durar minutos,
+		// el límite lo pone el contexto de cada llamada.
+		httpClient: &http.Client{},
+	}
+}
+
+// ServiceInstance es una instancia de un servicio registrada en Consul
+type ServiceInstance struct {
+	ID      string
+	Address string
+	Port    int
+}
+
+// URL devuelve la URL base http://host:port de la instancia
+func (si ServiceInstance) URL() string {
+	return fmt.Sprintf("http://%s:%d", si.Address, si.Port)
+}
+
+type consulHealthEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string `json:"ID"`
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+	} `json:"Service"`
+}
+
+// HealthyInstances consulta /v1/health/service/<name>?passing. Si index > 0 la
+// consulta es bloqueante hasta que cambie el catálogo o venza wait.
+func (c *ConsulClient) HealthyInstances(ctx context.Context, service string, index uint64, wait time.Duration) ([]ServiceInstance, uint64, error) {
+	query := url.Values{}
+	query.Set("passing", "true")
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(wait.Seconds())))
+	}
+	endpoint := c.baseURL + "/v1/health/service/" + url.PathEscape(service) + "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul returned status %d for service %s", resp.StatusCode, service)
+	}
+
+	var entries []consulHealthEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("decoding consul response: %w", err)
+	}
+
+	newIndex, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	instances := make([]ServiceInstance, 0, len(entries))
+	for _, entry := range entries {
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		instances = append(instances, ServiceInstance{
+			ID:      entry.Service.ID,
+			Address: address,
+			Port:    entry.Service.Port,
+		})
+	}
+	return instances, newIndex, nil
+}
+
+// ============================================
+// CONSUL - DESCUBRIMIENTO DE UPSTREAMS
+// ============================================
+
+// ServiceDiscovery mantiene la lista de instancias sanas de cada upstream
+type ServiceDiscovery struct {
+	client   *ConsulClient
+	services map[string]string // upstream -> nombre del servicio en Consul
+	wait     time.Duration
+
+	mu        sync.RWMutex
+	instances map[string][]ServiceInstance
+	next      map[string]int
+}
+
+func NewServiceDiscovery(client *ConsulClient, services map[string]string, wait time.Duration) *ServiceDiscovery {
+	return &ServiceDiscovery{
+		client:    client,
+		services:  services,
+		wait:      wait,
+		instances: make(map[string][]ServiceInstance),
+		next:      make(map[string]int),
+	}
+}
+
+// Start lanza un watcher por upstream que se detiene al cancelar ctx
+func (sd *ServiceDiscovery) Start(ctx context.Context) {
+	for upstream, service := range sd.services {
+		go sd.watch(ctx, upstream, service)
+	}
+}
+
+func (sd *ServiceDiscovery) watch(ctx context.Context, upstream, service string) {
+	var index uint64
+	backoff := time.Second
+
+	for {
+		instances, newIndex, err := sd.client.HealthyInstances(ctx, service, index, sd.wait)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Warn("Consul lookup failed", "service", service, "error", err, "retry_in", backoff.String())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+
+		if newIndex != index || index == 0 {
+			sd.setInstances(upstream, instances)
+		}
+
+		// Si el índice retrocede (p. ej. reinicio de Consul) o no viene, se
+		// vuelve a empezar esperando un poco para no consultar en bucle
+		if newIndex < index || newIndex == 0 {
+			index = 0
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		index = newIndex
+	}
+}
+
+func (sd *ServiceDiscovery) setInstances(upstream string, instances []ServiceInstance) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	sd.instances[upstream] = instances
+	slog.Info("Discovered passing instances", "upstream", upstream, "instances", len(instances))
+}
+
+// Resolve devuelve la URL de una instancia sana (round robin)
+func (sd *ServiceDiscovery) Resolve(upstream string) (string, bool) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	instances := sd.instances[upstream]
+	if len(instances) == 0 {
+		return "", false
+	}
+	i := sd.next[upstream] % len(instances)
+	sd.next[upstream] = i + 1
+	return instances[i].URL(), true
+}
+
+// Instances devuelve una copia de las instancias conocidas de un upstream
+func (sd *ServiceDiscovery) Instances(upstream string) []ServiceInstance {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+	return append([]ServiceInstance(nil), sd.instances[upstream]...)
+}
+
+// ============================================
+// CONSUL - AUTO-REGISTRO DEL GATEWAY
+// ============================================
+
+// AgentServiceCheck es el health check HTTP que Consul ejecuta contra el gateway
+type AgentServiceCheck struct {
+	HTTP                           string `json:"HTTP"`
+	Interval                       string `json:"Interval"`
+	Timeout                        string `json:"Timeout"`
+	DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter,omitempty"`
+}
+
+// AgentServiceRegistration es el cuerpo de PUT /v1/agent/service/register
+type AgentServiceRegistration struct {
+	ID      string             `json:"ID"`
+	Name    string             `json:"Name"`
+	Address string             `json:"Address"`
+	Port    int                `json:"Port"`
+	Tags    []string           `json:"Tags"`
+	Meta    map[string]string  `json:"Meta"`
+	Check   *AgentServiceCheck `json:"Check,omitempty"`
+}
+
+// RegisterService registra un servicio en el agente local de Consul
+func (c *ConsulClient) RegisterService(ctx context.Context, registration *AgentServiceRegistration) error {
+	body, err := json.Marshal(registration)
+	if err != nil {
+		return err
+	}
+	return c.agentPut(ctx, "/v1/agent/service/register", body)
+}
+
+// DeregisterService elimina un servicio del agente local de Consul
+func (c *ConsulClient) DeregisterService(ctx context.Context, serviceID string) error {
+	return c.agentPut(ctx, "/v1/agent/service/deregister/"+url.PathEscape(serviceID), nil)
+}
+
+func (c *ConsulClient) agentPut(ctx context.Context, path string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("consul returned status %d for %s: %s", resp.StatusCode, path, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// gatewayRegistration arma el registro que espera prometheus.yml: tag
+// "prometheus" y metadata metrics_path, con health check sobre /health
+func gatewayRegistration(config *Config) (*AgentServiceRegistration, error) {
+	port, err := strconv.Atoi(config.Port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gateway port %q: %w", config.Port, err)
+	}
+
+	baseURL := fmt.Sprintf("http://%s:%d", config.ServiceAddress, port)
+	return &AgentServiceRegistration{
+		ID:      config.ServiceID,
+		Name:    config.ServiceName,
+		Address: config.ServiceAddress,
+		Port:    port,
+		Tags:    []string{"prometheus", "api-gateway"},
+		Meta: map[string]string{
+			"metrics_path": config.MetricsPath,
+		},
+		Check: &AgentServiceCheck{
+			HTTP:                           baseURL + "/health",
+			Interval:                       "10s",
+			Timeout:                        "2s",
+			DeregisterCriticalServiceAfter: "1m",
+		},
+	}, nil
+}
+
+// registerGateway registra el gateway en Consul y devuelve la función que lo
+// da de baja. Un fallo no impide arrancar: Prometheus simplemente no lo verá.
+func registerGateway(client *ConsulClient, config *Config) func() {
+	registration, err := gatewayRegistration(config)
+	if err != nil {
+		slog.Warn("Skipping Consul registration", "error", err)
+		return func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.RegisterService(ctx, registration); err != nil {
+		slog.Error("Consul registration failed", "error", err)
+		return func() {}
+	}
+	slog.Info("Registered in Consul", "name", registration.Name, "id", registration.ID)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.DeregisterService(ctx, registration.ID); err != nil {
+			slog.Error("Consul deregistration failed", "error", err)
+			return
+		}
+		slog.Info("Deregistered from Consul", "id", registration.ID)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul simula /v1/health/service/<name> con soporte de consultas bloqueantes
+type fakeConsul struct {
+	mu      sync.Mutex
+	index   uint64
+	entries map[string][]consulHealthEntry
+	changed chan struct{}
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{index: 1, entries: map[string][]consulHealthEntry{}, changed: make(chan struct{})}
+}
+
+func (f *fakeConsul) set(service string, ports ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var entries []consulHealthEntry
+	for _, port := range ports {
+		var entry consulHealthEntry
+		entry.Node.Address = "10.0.0.1"
+		entry.Service.ID = fmt.Sprintf("%s-%d", service, port)
+		entry.Service.Port = port
+		entries = append(entries, entry)
+	}
+	f.entries[service] = entries
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Path[len("/v1/health/service/"):]
+	if r.URL.Query().Get("passing") != "true" {
+		http.Error(w, "passing filter expected", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	index, changed := f.index, f.changed
+	f.mu.Unlock()
+
+	if requested, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); requested >= index {
+		select {
+		case <-changed:
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	json.NewEncoder(w).Encode(f.entries[service])
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met before deadline")
+}
+
+func TestServiceDiscoveryWatchesConsul(t *testing.T) {
+	consul := newFakeConsul()
+	consul.set("auth-service", 3500)
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sd := NewServiceDiscovery(NewConsulClient(server.URL), map[string]string{"auth": "auth-service"}, time.Second)
+	sd.Start(ctx)
+
+	waitFor(t, func() bool { return len(sd.Instances("auth")) == 1 })
+	if got, _ := sd.Resolve("auth"); got != "http://10.0.0.1:3500" {
+		t.Fatalf("Resolve() = %s", got)
+	}
+
+	// Un cambio en el catálogo llega por la consulta bloqueante
+	consul.set("auth-service", 3500, 3501)
+	waitFor(t, func() bool { return len(sd.Instances("auth")) == 2 })
+
+	first, _ := sd.Resolve("auth")
+	second, _ := sd.Resolve("auth")
+	if first == second {
+		t.Fatalf("expected round robin between instances, got %s twice", first)
+	}
+}
+
+func TestUpstreamURLFallsBackToStatic(t *testing.T) {
+	g := NewGateway(&Config{AuthServiceURL: "http://auth:3500"})
+	g.discovery = NewServiceDiscovery(NewConsulClient("http://unused"), nil, time.Second)
+
+	if got := g.upstreamURL("auth"); got != "http://auth:3500" {
+		t.Fatalf("upstreamURL() = %s, want static fallback", got)
+	}
+
+	g.discovery.setInstances("auth", []ServiceInstance{{Address: "auth-1", Port: 3500}})
+	if got := g.upstreamURL("auth"); got != "http://auth-1:3500" {
+		t.Fatalf("upstreamURL() = %s, want discovered instance", got)
+	}
+
+	status := g.upstreamStatus("auth")
+	if status["source"] != "consul" {
+		t.Fatalf("upstreamStatus() = %v", status)
+	}
+}
+
+func TestRegisterGatewayAgainstStubAgent(t *testing.T) {
+	var (
+		mu           sync.Mutex
+		registered   *AgentServiceRegistration
+		deregistered string
+	)
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != "PUT" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch {
+		case r.URL.Path == "/v1/agent/service/register":
+			registered = &AgentServiceRegistration{}
+			if err := json.NewDecoder(r.Body).Decode(registered); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+			deregistered = strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer agent.Close()
+
+	config := &Config{
+		Port:           "8888",
+		ServiceName:    "api-gateway",
+		ServiceID:      "api-gateway-1",
+		ServiceAddress: "api-gateway",
+		MetricsPath:    "/metrics",
+	}
+	deregister := registerGateway(NewConsulClient(agent.URL), config)
+
+	mu.Lock()
+	if registered == nil {
+		mu.Unlock()
+		t.Fatal("gateway was not registered")
+	}
+	if registered.ID != "api-gateway-1" || registered.Port != 8888 {
+		t.Errorf("unexpected registration %+v", registered)
+	}
+	hasTag := false
+	for _, tag := range registered.Tags {
+		hasTag = hasTag || tag == "prometheus"
+	}
+	if !hasTag {
+		t.Errorf("missing prometheus tag: %v", registered.Tags)
+	}
+	if registered.Meta["metrics_path"] != "/metrics" {
+		t.Errorf("metrics_path meta = %q", registered.Meta["metrics_path"])
+	}
+	if registered.Check == nil || registered.Check.HTTP != "http://api-gateway:8888/health" {
+		t.Errorf("unexpected check %+v", registered.Check)
+	}
+	mu.Unlock()
+
+	deregister()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if deregistered != "api-gateway-1" {
+		t.Fatalf("deregistered = %q, want api-gateway-1", deregistered)
+	}
+}
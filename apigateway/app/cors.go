@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+// ============================================
+// CORS - POLÍTICA CONFIGURABLE
+// ============================================
+
+// corsMethods son los métodos que se prueban contra el router para saber qué
+// admite una ruta en un preflight
+var corsMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// CORSRouteRule restringe métodos y cabeceras para un grupo de rutas. Paths
+// son plantillas de mux; un * al final cubre el prefijo.
+type CORSRouteRule struct {
+	Paths   []string `yaml:"paths"`
+	Methods []string `yaml:"methods"`
+	Headers []string `yaml:"headers"`
+}
+
+// CORSPolicy es el contenido de config/cors.yaml
+type CORSPolicy struct {
+	AllowedOrigins   []string        `yaml:"allowed_origins"`
+	AllowCredentials bool            `yaml:"allow_credentials"`
+	AllowedHeaders   []string        `yaml:"allowed_headers"`
+	ExposedHeaders   []string        `yaml:"exposed_headers"`
+	MaxAge           time.Duration   `yaml:"-"`
+	Routes           []CORSRouteRule `yaml:"routes"`
+}
+
+// LoadCORSPolicy lee y valida la política CORS (YAML o JSON)
+func LoadCORSPolicy(path string) (*CORSPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CORS policy %s: %w", path, err)
+	}
+	return parseCORSPolicy(data)
+}
+
+func parseCORSPolicy(data []byte) (*CORSPolicy, error) {
+	var raw struct {
+		CORSPolicy `yaml:",inline"`
+		MaxAge     string `yaml:"max_age"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing CORS policy: %w", err)
+	}
+
+	policy := raw.CORSPolicy
+	if raw.MaxAge != "" {
+		maxAge, err := time.ParseDuration(raw.MaxAge)
+		if err != nil || maxAge < 0 {
+			return nil, fmt.Errorf("CORS policy: invalid max_age %q", raw.MaxAge)
+		}
+		policy.MaxAge = maxAge
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("CORS policy: %w", err)
+	}
+	for i, rule := range policy.Routes {
+		if len(rule.Paths) == 0 {
+			return nil, fmt.Errorf("CORS route rule %d: paths are required", i)
+		}
+		for j, method := range rule.Methods {
+			policy.Routes[i].Methods[j] = strings.ToUpper(method)
+		}
+	}
+	return &policy, nil
+}
+
+// validate revisa los orígenes. "*" no se admite junto con credenciales: el
+// middleware refleja el origen, y eso daría acceso con cookies a cualquier
+// sitio.
+func (p *CORSPolicy) validate() error {
+	for _, origin := range p.AllowedOrigins {
+		if err := validateOriginPattern(origin); err != nil {
+			return err
+		}
+		if origin == "*" && p.AllowCredentials {
+			return fmt.Errorf(`origin "*" cannot be combined with allow_credentials`)
+		}
+	}
+	return nil
+}
+
+// validateOriginPattern acepta "*", un origen exacto (https://app.example.com)
+// o un comodín de subdominio (https://*.example.com)
+func validateOriginPattern(pattern string) error {
+	if pattern == "*" {
+		return nil
+	}
+	u, err := url.Parse(strings.Replace(pattern, "*.", "wildcard.", 1))
+	if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return fmt.Errorf("invalid origin pattern %q", pattern)
+	}
+	if strings.Count(pattern, "*") > 1 || (strings.Contains(pattern, "*") && !strings.Contains(pattern, "://*.")) {
+		return fmt.Errorf("origin pattern %q: only a leading *. subdomain wildcard is supported", pattern)
+	}
+	return nil
+}
+
+// allowsOrigin indica si el origen está en la lista
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+	for _, pattern := range p.AllowedOrigins {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "/"))
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		// https://*.example.com cubre https://app.example.com y
+		// https://a.b.example.com, pero no https://example.com
+		if prefix, suffix, ok := strings.Cut(pattern, "*"); ok {
+			if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				sub := origin[len(prefix) : len(origin)-len(suffix)]
+				if sub != "" && !strings.ContainsAny(sub, "/:") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ruleFor devuelve la regla de la ruta, o nil si usa la política general
+func (p *CORSPolicy) ruleFor(template string) *CORSRouteRule {
+	for i := range p.Routes {
+		if matchesRouteTemplate(p.Routes[i].Paths, template) {
+			return &p.Routes[i]
+		}
+	}
+	return nil
+}
+
+// ============================================
+// MIDDLEWARE - CORS
+// ============================================
+
+// corsMiddleware aplica la política CORS. Sin cabecera Origin la petición no
+// viene de un navegador con otro origen y pasa sin cabeceras CORS.
+func (g *Gateway) corsMiddleware(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := g.cors
+		origin := r.Header.Get("Origin")
+		if policy == nil || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// La respuesta depende del origen: los caches no deben mezclarlas
+		w.Header().Add("Vary", "Origin")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			g.handlePreflight(w, r, router, policy, origin)
+			return
+		}
+
+		if policy.allowsOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if policy.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if len(policy.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlePreflight responde un OPTIONS de CORS. Los métodos permitidos salen
+// del router, así una ruta que no existe o un método que no tiene se
+// rechazan en vez de contestar 200 a todo.
+func (g *Gateway) handlePreflight(w http.ResponseWriter, r *http.Request, router *mux.Router, policy *CORSPolicy, origin string) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	if !policy.allowsOrigin(origin) {
+		writeJSONError(w, http.StatusForbidden, "Origin not allowed")
+		return
+	}
+
+	methods, template := routeMethods(router, r)
+	if len(methods) == 0 {
+		writeJSONError(w, http.StatusNotFound, "Unknown route")
+		return
+	}
+
+	headers := policy.AllowedHeaders
+	if rule := policy.ruleFor(template); rule != nil {
+		if len(rule.Methods) > 0 {
+			methods = intersect(methods, rule.Methods)
+		}
+		if rule.Headers != nil {
+			headers = rule.Headers
+		}
+	}
+
+	requested := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if !containsFold(methods, requested) {
+		w.Header().Set("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed for this route")
+		return
+	}
+
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if header = strings.TrimSpace(header); header != "" && !containsFold(headers, header) {
+			writeJSONError(w, http.StatusForbidden, "Header "+header+" not allowed")
+			return
+		}
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if policy.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	if policy.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// routeMethods prueba cada método contra el router y devuelve los que tienen
+// ruta para el path pedido, junto con la plantilla de esa ruta
+func routeMethods(router *mux.Router, r *http.Request) ([]string, string) {
+	var methods []string
+	template := ""
+	for _, method := range corsMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.Route != nil {
+			methods = append(methods, method)
+			if template == "" {
+				template, _ = match.Route.GetPathTemplate()
+			}
+		}
+	}
+	return methods, template
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func intersect(values, allowed []string) []string {
+	var result []string
+	for _, v := range values {
+		if containsFold(allowed, v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
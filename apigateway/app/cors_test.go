@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadCORSPolicyDefaultFile(t *testing.T) {
+	policy, err := LoadCORSPolicy("../config/cors.yaml")
+	if err != nil {
+		t.Fatalf("LoadCORSPolicy() error = %v", err)
+	}
+	if len(policy.AllowedOrigins) == 0 || policy.MaxAge <= 0 {
+		t.Fatalf("unexpected default policy %+v", policy)
+	}
+}
+
+func TestCORSOriginPatterns(t *testing.T) {
+	policy := &CORSPolicy{AllowedOrigins: []string{"http://localhost:5173", "https://*.example.com"}}
+	cases := map[string]bool{
+		"http://localhost:5173":           true,
+		"http://localhost:5174":           false,
+		"https://app.example.com":         true,
+		"https://a.b.example.com":         true,
+		"https://example.com":             false,
+		"http://app.example.com":          false,
+		"https://evil.com/.example.com":   false,
+		"https://app.example.com.evil.io": false,
+	}
+	for origin, want := range cases {
+		if got := policy.allowsOrigin(origin); got != want {
+			t.Errorf("allowsOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+
+	if err := validateOriginPattern("https://app.*.com"); err == nil {
+		t.Error("expected error for wildcard in the middle")
+	}
+
+	// "*" con credenciales sería cualquier sitio con cookies
+	if _, err := parseCORSPolicy([]byte("allowed_origins: [\"*\"]\nallow_credentials: true\n")); err == nil {
+		t.Error("expected error for * with allow_credentials")
+	}
+	if _, err := parseCORSPolicy([]byte("allowed_origins: [\"*\"]\n")); err != nil {
+		t.Errorf("* without credentials: error = %v", err)
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	g := NewGateway(&Config{})
+	g.cors = &CORSPolicy{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowCredentials: true,
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		MaxAge:           10 * time.Minute,
+		Routes:           []CORSRouteRule{{Paths: []string{"/api/v1/users/*"}, Methods: []string{"GET", "PATCH"}}},
+	}
+	router := g.setupRoutes()
+	handler := g.corsMiddleware(router, router)
+
+	preflight := func(origin, path, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := preflight("https://app.example.com", "/api/v1/users/bob/profile", "PATCH", "authorization, content-type")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("valid preflight status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, PATCH" {
+		t.Errorf("Allow-Methods = %q, want route methods limited by rule", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		rec.Header().Get("Access-Control-Allow-Credentials") != "true" ||
+		rec.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("unexpected preflight headers %v", rec.Header())
+	}
+	if vary := strings.Join(rec.Header().Values("Vary"), ","); !strings.Contains(vary, "Origin") {
+		t.Errorf("Vary = %q", vary)
+	}
+
+	for name, tc := range map[string]struct {
+		origin, path, method, headers string
+		want                          int
+	}{
+		"unknown origin":     {"https://evil.io", "/api/v1/users/bob/profile", "GET", "", http.StatusForbidden},
+		"unknown route":      {"https://app.example.com", "/api/v1/nope", "GET", "", http.StatusNotFound},
+		"method not routed":  {"https://app.example.com", "/health", "DELETE", "", http.StatusMethodNotAllowed},
+		"method not in rule": {"https://app.example.com", "/api/v1/users/bob/profile", "PUT", "", http.StatusMethodNotAllowed},
+		"header not allowed": {"https://app.example.com", "/health", "GET", "x-custom", http.StatusForbidden},
+	} {
+		if rec := preflight(tc.origin, tc.path, tc.method, tc.headers); rec.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", name, rec.Code, tc.want)
+		}
+	}
+
+	// Petición normal: se refleja el origen permitido y las cabeceras expuestas
+	req := httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		rec.Header().Get("Access-Control-Expose-Headers") != "X-Request-ID" {
+		t.Errorf("unexpected CORS headers on GET: %v", rec.Header())
+	}
+
+	req = httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("Origin", "https://evil.io")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("disallowed origin got Allow-Origin %q", rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
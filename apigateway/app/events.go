@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ============================================
+// EVENTOS DE DOMINIO
+// ============================================
+
+// DomainEvent tiene el mismo formato que AuthEvent del orquestador
+type DomainEvent struct {
+	ID         string                 `json:"id"`
+	Type       string                 `json:"type"`
+	RoutingKey string                 `json:"-"`
+	Data       map[string]interface{} `json:"data"`
+	Meta       map[string]interface{} `json:"meta"`
+}
+
+// newEventID genera un identificador aleatorio para el evento
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// EventPublisher entrega un evento al broker y solo retorna nil si el broker
+// lo confirmó
+type EventPublisher interface {
+	Publish(ctx context.Context, event *DomainEvent) error
+}
+
+// eventHeaders pasa el request id y el span de la publicación como cabeceras
+// AMQP, para que los consumidores continúen la traza de la petición que
+// generó el evento
+func eventHeaders(ctx context.Context, event *DomainEvent) amqp.Table {
+	headers := amqp.Table{}
+	if requestID, ok := event.Meta["requestId"].(string); ok && requestID != "" {
+		headers["requestId"] = requestID
+	}
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = eventTraceContext(ctx, event)
+	}
+	carrier := propagation.MapCarrier{}
+	traceContextPropagator.Inject(ctx, carrier)
+	if traceparent := carrier.Get(traceparentHeader); traceparent != "" {
+		headers[traceparentHeader] = traceparent
+	}
+	return headers
+}
+
+// ============================================
+// RABBITMQ - PUBLICADOR CON CONFIRMS
+// ============================================
+
+// amqpChannel es lo que el publicador usa de un canal AMQP en modo confirm.
+// rabbitChannel lo implementa sobre amqp091; en los tests se reemplaza por un
+// canal falso para simular acks, nacks y conexiones caídas.
+type amqpChannel interface {
+	Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) (amqpConfirmation, error)
+	IsClosed() bool
+	Close() error
+}
+
+// amqpConfirmation es la confirmación pendiente de un mensaje publicado
+type amqpConfirmation interface {
+	WaitContext(ctx context.Context) (bool, error)
+}
+
+type rabbitChannel struct {
+	*amqp.Channel
+}
+
+func (c rabbitChannel) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) (amqpConfirmation, error) {
+	return c.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, msg)
+}
+
+// RabbitPublisher publica en el exchange topic de eventos (AUTH_EVENTS_EXCHANGE)
+// con mensajes persistentes y publisher confirms
+type RabbitPublisher struct {
+	url            string
+	exchange       string
+	confirmTimeout time.Duration
+
+	// open abre un canal nuevo en modo confirm (openChannel fuera de los tests)
+	open func() (amqpChannel, error)
+
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	channel amqpChannel
+}
+
+func NewRabbitPublisher(url, exchange string, confirmTimeout time.Duration) *RabbitPublisher {
+	p := &RabbitPublisher{
+		url:            url,
+		exchange:       exchange,
+		confirmTimeout: confirmTimeout,
+	}
+	p.open = p.openChannel
+	return p
+}
+
+// connect devuelve el canal abierto o abre uno nuevo si se cerró
+func (p *RabbitPublisher) connect() (amqpChannel, error) {
+	if p.channel != nil && !p.channel.IsClosed() {
+		return p.channel, nil
+	}
+	channel, err := p.open()
+	if err != nil {
+		return nil, err
+	}
+	p.channel = channel
+	return channel, nil
+}
+
+// openChannel abre (o reabre) la conexión y un canal en modo confirm
+func (p *RabbitPublisher) openChannel() (amqpChannel, error) {
+	if p.conn == nil || p.conn.IsClosed() {
+		conn, err := amqp.Dial(p.url)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to rabbitmq: %w", err)
+		}
+		p.conn = conn
+	}
+
+	channel, err := p.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("opening channel: %w", err)
+	}
+	if err := channel.Confirm(false); err != nil {
+		channel.Close()
+		return nil, fmt.Errorf("enabling publisher confirms: %w", err)
+	}
+	return rabbitChannel{channel}, nil
+}
+
+func (p *RabbitPublisher) Publish(ctx context.Context, event *DomainEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshaling event: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	channel, err := p.connect()
+	if err != nil {
+		return err
+	}
+
+	confirmation, err := channel.Publish(ctx, p.exchange, event.RoutingKey, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    event.ID,
+		Timestamp:    time.Now(),
+		Type:         event.Type,
+		AppId:        "api-gateway",
+		Headers:      eventHeaders(ctx, event),
+		Body:         body,
+	})
+	if err != nil {
+		channel.Close()
+		return fmt.Errorf("publishing %s: %w", event.Type, err)
+	}
+
+	confirmCtx, cancel := context.WithTimeout(ctx, p.confirmTimeout)
+	defer cancel()
+	acked, err := confirmation.WaitContext(confirmCtx)
+	if err != nil {
+		channel.Close()
+		return fmt.Errorf("waiting confirm for %s: %w", event.Type, err)
+	}
+	if !acked {
+		return fmt.Errorf("broker nacked %s", event.Type)
+	}
+	return nil
+}
+
+// Close cierra canal y conexión
+func (p *RabbitPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.channel != nil {
+		p.channel.Close()
+	}
+	if p.conn != nil {
+		return p.conn.Close()
+	}
+	return nil
+}
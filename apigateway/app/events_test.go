@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeConfirmation responde como el broker: ack, nack o nada (timeout)
+type fakeConfirmation struct {
+	acked   bool
+	pending bool
+}
+
+func (c fakeConfirmation) WaitContext(ctx context.Context) (bool, error) {
+	if c.pending {
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+	return c.acked, nil
+}
+
+// fakeChannel guarda lo publicado y confirma según confirm
+type fakeChannel struct {
+	mu         sync.Mutex
+	confirm    fakeConfirmation
+	publishErr error
+	closed     bool
+	published  []amqp.Publishing
+	keys       []string
+}
+
+func (c *fakeChannel) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) (amqpConfirmation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.publishErr != nil {
+		return nil, c.publishErr
+	}
+	c.published = append(c.published, msg)
+	c.keys = append(c.keys, key)
+	return c.confirm, nil
+}
+
+func (c *fakeChannel) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// fakeBroker entrega los canales en orden, uno por cada apertura
+func fakeBroker(p *RabbitPublisher, channels ...*fakeChannel) *int {
+	opened := 0
+	p.open = func() (amqpChannel, error) {
+		if opened >= len(channels) {
+			return nil, errors.New("connection refused")
+		}
+		opened++
+		return channels[opened-1], nil
+	}
+	return &opened
+}
+
+func testEvent() *DomainEvent {
+	return &DomainEvent{ID: "evt-1", Type: "user.deleted", RoutingKey: "user.deleted", Meta: map[string]interface{}{"requestId": "req-1"}}
+}
+
+func TestRabbitPublisherAck(t *testing.T) {
+	channel := &fakeChannel{confirm: fakeConfirmation{acked: true}}
+	p := NewRabbitPublisher("", "auth.events", time.Second)
+	opened := fakeBroker(p, channel)
+
+	for i := 0; i < 2; i++ {
+		if err := p.Publish(context.Background(), testEvent()); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+	if *opened != 1 {
+		t.Errorf("channels opened = %d, want the first one reused", *opened)
+	}
+	msg := channel.published[0]
+	if channel.keys[0] != "user.deleted" || msg.DeliveryMode != amqp.Persistent || msg.MessageId != "evt-1" || msg.Headers["requestId"] != "req-1" {
+		t.Errorf("published %q %+v", channel.keys[0], msg)
+	}
+}
+
+func TestRabbitPublisherNack(t *testing.T) {
+	p := NewRabbitPublisher("", "auth.events", time.Second)
+	fakeBroker(p, &fakeChannel{confirm: fakeConfirmation{acked: false}})
+
+	err := p.Publish(context.Background(), testEvent())
+	if err == nil || !strings.Contains(err.Error(), "nacked") {
+		t.Fatalf("Publish() error = %v, want nack", err)
+	}
+}
+
+func TestRabbitPublisherConfirmTimeout(t *testing.T) {
+	silent := &fakeChannel{confirm: fakeConfirmation{pending: true}}
+	healthy := &fakeChannel{confirm: fakeConfirmation{acked: true}}
+	p := NewRabbitPublisher("", "auth.events", 20*time.Millisecond)
+	opened := fakeBroker(p, silent, healthy)
+
+	err := p.Publish(context.Background(), testEvent())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Publish() error = %v, want confirm timeout", err)
+	}
+	if !silent.IsClosed() {
+		t.Fatal("channel without confirm was not closed")
+	}
+
+	// Sin confirm no se sabe el estado del canal: se publica en uno nuevo
+	if err := p.Publish(context.Background(), testEvent()); err != nil {
+		t.Fatalf("Publish() after timeout error = %v", err)
+	}
+	if *opened != 2 || len(healthy.published) != 1 {
+		t.Errorf("channels opened = %d, published on new channel = %d", *opened, len(healthy.published))
+	}
+}
+
+func TestRabbitPublisherReopensAfterConnectionLoss(t *testing.T) {
+	first := &fakeChannel{confirm: fakeConfirmation{acked: true}}
+	second := &fakeChannel{confirm: fakeConfirmation{acked: true}}
+	p := NewRabbitPublisher("", "auth.events", time.Second)
+	opened := fakeBroker(p, first, second)
+
+	if err := p.Publish(context.Background(), testEvent()); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	// Se cae la conexión: el canal queda cerrado y publicar en él falla
+	first.Close()
+	first.publishErr = amqp.ErrClosed
+	if err := p.Publish(context.Background(), testEvent()); err != nil {
+		t.Fatalf("Publish() after connection loss error = %v", err)
+	}
+	if *opened != 2 || len(second.published) != 1 {
+		t.Errorf("channels opened = %d, published on new channel = %d", *opened, len(second.published))
+	}
+
+	// Si el broker sigue caído el error llega al outbox, que reintenta
+	second.Close()
+	if err := p.Publish(context.Background(), testEvent()); err == nil {
+		t.Fatal("Publish() with broker down returned nil")
+	}
+}
+
+func TestRabbitPublisherClosesChannelOnPublishError(t *testing.T) {
+	broken := &fakeChannel{publishErr: amqp.ErrClosed}
+	healthy := &fakeChannel{confirm: fakeConfirmation{acked: true}}
+	p := NewRabbitPublisher("", "auth.events", time.Second)
+	fakeBroker(p, broken, healthy)
+
+	if err := p.Publish(context.Background(), testEvent()); !errors.Is(err, amqp.ErrClosed) {
+		t.Fatalf("Publish() error = %v, want ErrClosed", err)
+	}
+	if err := p.Publish(context.Background(), testEvent()); err != nil {
+		t.Fatalf("Publish() on reopened channel error = %v", err)
+	}
+	if len(healthy.published) != 1 {
+		t.Errorf("published on new channel = %d, want 1", len(healthy.published))
+	}
+}
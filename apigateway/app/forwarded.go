@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ============================================
+// CABECERAS DE PROXY
+// ============================================
+
+// hopByHopHeaders son las cabeceras de una sola conexión (RFC 7230 6.1): no
+// se reenvían ni al upstream ni al cliente
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection", // no estándar, pero la mandan clientes viejos
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHop quita las cabeceras hop-by-hop, incluidas las que la
+// cabecera Connection nombra
+func removeHopByHop(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// copyRequestHeaders copia las cabeceras del cliente para el upstream, sin
+// las hop-by-hop ni las de reenvío (las genera setForwardedHeaders)
+func copyRequestHeaders(dst, src http.Header) {
+	for key, values := range src {
+		dst[key] = append([]string(nil), values...)
+	}
+	removeHopByHop(dst)
+	for _, name := range forwardingHeaders {
+		dst.Del(name)
+	}
+	dst.Del("Content-Length")
+}
+
+// copyResponseHeaders copia las cabeceras del upstream a la respuesta sin las
+// hop-by-hop ni Content-Length: el gateway escribe el body por su cuenta y
+// net/http calcula el tamaño
+func copyResponseHeaders(dst, src http.Header) {
+	for key, values := range src {
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+	removeHopByHop(dst)
+	dst.Del("Content-Length")
+}
+
+// ============================================
+// X-FORWARDED-* Y FORWARDED
+// ============================================
+
+var forwardingHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"}
+
+// TrustedProxies son las redes de los proxies (balanceador, ingress) cuyas
+// cabeceras de reenvío se conservan. De cualquier otro origen se descartan:
+// el cliente podría inventarse su IP.
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies lee una lista separada por comas de IPs o CIDRs
+func ParseTrustedProxies(value string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+// Contains indica si addr es un proxy de confianza
+func (tp TrustedProxies) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range tp {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerAddr es la IP de quien abrió la conexión con el gateway
+func peerAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// clientIP devuelve la IP del cliente. Si la conexión viene de un proxy de
+// confianza se recorre X-Forwarded-For de derecha a izquierda saltando los
+// proxies de confianza; la primera IP que no lo es, es el cliente.
+func (g *Gateway) clientIP(r *http.Request) string {
+	client, ok := peerAddr(r)
+	if !ok {
+		return r.RemoteAddr
+	}
+	if !g.config.TrustedProxies.Contains(client) {
+		return client.String()
+	}
+
+	hops := forwardedForHops(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+		if !g.config.TrustedProxies.Contains(client) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func forwardedForHops(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// setForwardedHeaders arma X-Forwarded-For/Proto/Host y Forwarded para la
+// petición al upstream. Si r llega de un proxy de confianza se conservan las
+// cabeceras que trae y se agrega este salto; si no, se reemplazan.
+func (g *Gateway) setForwardedHeaders(out http.Header, r *http.Request) {
+	peer, ok := peerAddr(r)
+	trusted := ok && g.config.TrustedProxies.Contains(peer)
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	forwardedFor := ""
+	if ok {
+		forwardedFor = peer.String()
+	}
+	element := forwardedElement(peer, ok, r.Host, proto)
+
+	if trusted {
+		if prior := strings.Join(r.Header.Values("X-Forwarded-For"), ", "); prior != "" {
+			forwardedFor = prior + ", " + forwardedFor
+		}
+		if prior := r.Header.Get("X-Forwarded-Proto"); prior != "" {
+			proto = prior
+		}
+		if prior := strings.Join(r.Header.Values("Forwarded"), ", "); prior != "" {
+			element = prior + ", " + element
+		}
+	}
+	host := r.Host
+	if prior := r.Header.Get("X-Forwarded-Host"); trusted && prior != "" {
+		host = prior
+	}
+
+	for _, name := range forwardingHeaders {
+		out.Del(name)
+	}
+	if forwardedFor != "" {
+		out.Set("X-Forwarded-For", forwardedFor)
+	}
+	out.Set("X-Forwarded-Proto", proto)
+	if host != "" {
+		out.Set("X-Forwarded-Host", host)
+	}
+	out.Set("Forwarded", element)
+}
+
+// forwardedElement es el elemento de este salto en Forwarded (RFC 7239). Las
+// IPv6 van entre comillas y corchetes.
+func forwardedElement(peer netip.Addr, known bool, host, proto string) string {
+	forwardedFor := "unknown"
+	if known {
+		forwardedFor = peer.String()
+		if peer.Is6() {
+			forwardedFor = `"[` + forwardedFor + `]"`
+		}
+	}
+	element := "for=" + forwardedFor
+	if host != "" {
+		element += ";host=" + quoteForwarded(host)
+	}
+	return element + ";proto=" + proto
+}
+
+// quoteForwarded pone entre comillas los valores que no son un token
+func quoteForwarded(value string) string {
+	for _, c := range value {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+		}
+	}
+	return value
+}
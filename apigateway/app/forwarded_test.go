@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies(" 10.0.0.0/8, 192.168.1.10 ,::1")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies() error = %v", err)
+	}
+	for addr, want := range map[string]bool{"10.1.2.3": true, "192.168.1.10": true, "192.168.1.11": false, "::1": true, "::ffff:10.0.0.1": true} {
+		if got := proxies.Contains(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Contains(%s) = %v, want %v", addr, got, want)
+		}
+	}
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+}
+
+func TestClientIPHonoursTrustedProxies(t *testing.T) {
+	proxies, _ := ParseTrustedProxies("10.0.0.0/8")
+	g := NewGateway(&Config{TrustedProxies: proxies})
+
+	cases := []struct {
+		name, remote, forwardedFor, want string
+	}{
+		{"direct client", "203.0.113.7:5000", "", "203.0.113.7"},
+		{"spoofed header from untrusted peer", "203.0.113.7:5000", "1.2.3.4", "203.0.113.7"},
+		{"behind trusted proxy", "10.0.0.2:5000", "198.51.100.9", "198.51.100.9"},
+		{"chain of trusted proxies", "10.0.0.2:5000", "1.2.3.4, 198.51.100.9, 10.0.0.5", "198.51.100.9"},
+		{"invalid hop", "10.0.0.2:5000", "garbage", "10.0.0.2"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		if got := g.clientIP(req); got != tc.want {
+			t.Errorf("%s: clientIP() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestProxyHeadersToUpstream(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	proxies, _ := ParseTrustedProxies("10.0.0.0/8")
+	g := streamingGateway(upstream.URL, Config{TrustedProxies: proxies})
+	router := g.setupRoutes()
+
+	newRequest := func(remote string) *http.Request {
+		req := httptest.NewRequest("GET", "http://gateway.example.com/search", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("Connection", "X-Hop")
+		req.Header.Set("X-Hop", "drop me")
+		req.Header.Set("Keep-Alive", "timeout=5")
+		req.Header.Set("Proxy-Authorization", "Basic abc")
+		req.Header.Set("X-Forwarded-For", "1.2.3.4")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "public.example.com")
+		req.Header.Set("Forwarded", "for=1.2.3.4;proto=https")
+		req.Header.Set("Accept", "application/json")
+		return req
+	}
+
+	// Cliente directo: las cabeceras de reenvío que trae se reemplazan
+	router.ServeHTTP(httptest.NewRecorder(), newRequest("203.0.113.7:5000"))
+	for _, name := range []string{"X-Hop", "Keep-Alive", "Proxy-Authorization"} {
+		if got.Get(name) != "" {
+			t.Errorf("hop-by-hop header %s reached the upstream", name)
+		}
+	}
+	want := map[string]string{
+		"Accept":            "application/json",
+		"X-Forwarded-For":   "203.0.113.7",
+		"X-Forwarded-Proto": "http",
+		"X-Forwarded-Host":  "gateway.example.com",
+		"Forwarded":         "for=203.0.113.7;host=gateway.example.com;proto=http",
+	}
+	for name, value := range want {
+		if got.Get(name) != value {
+			t.Errorf("untrusted peer: %s = %q, want %q", name, got.Get(name), value)
+		}
+	}
+
+	// Detrás de un proxy de confianza se conservan y se agrega este salto
+	router.ServeHTTP(httptest.NewRecorder(), newRequest("10.0.0.2:5000"))
+	want = map[string]string{
+		"X-Forwarded-For":   "1.2.3.4, 10.0.0.2",
+		"X-Forwarded-Proto": "https",
+		"X-Forwarded-Host":  "public.example.com",
+		"Forwarded":         "for=1.2.3.4;proto=https, for=10.0.0.2;host=gateway.example.com;proto=http",
+	}
+	for name, value := range want {
+		if got.Get(name) != value {
+			t.Errorf("trusted peer: %s = %q, want %q", name, got.Get(name), value)
+		}
+	}
+
+	// Las llamadas con body en memoria (handlers unificados) hacen lo mismo
+	req := newRequest("[2001:db8::1]:5000")
+	if resp := g.proxyRequest("profiles", upstream.URL+"/x", req, nil); resp.Error != nil {
+		t.Fatalf("proxyRequest() error = %v", resp.Error)
+	}
+	if got.Get("X-Hop") != "" || got.Get("X-Forwarded-For") != "2001:db8::1" ||
+		got.Get("Forwarded") != `for="[2001:db8::1]";host=gateway.example.com;proto=http` {
+		t.Errorf("buffered call headers = %v", got)
+	}
+}
+
+func TestCopyResponseHeadersDropsHopByHop(t *testing.T) {
+	src := http.Header{
+		"Connection":        {"X-Upstream-Hop"},
+		"X-Upstream-Hop":    {"1"},
+		"Transfer-Encoding": {"chunked"},
+		"Content-Length":    {"42"},
+		"Content-Type":      {"application/json"},
+		"Date":              {time.Now().Format(http.TimeFormat)},
+	}
+	dst := http.Header{}
+	copyResponseHeaders(dst, src)
+	if len(dst) != 2 || dst.Get("Content-Type") != "application/json" || dst.Get("Date") == "" {
+		t.Fatalf("copied headers = %v", dst)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ============================================
+// HEALTH - LIVENESS Y READINESS
+// ============================================
+
+// Estados de readiness. DEGRADED sigue aceptando tráfico: falla una
+// dependencia opcional y solo las rutas que la usan responden con error.
+const (
+	healthUp       = "UP"
+	healthDegraded = "DEGRADED"
+	healthDown     = "DOWN"
+)
+
+// DependencyCheck es un upstream que se consulta en /health/ready
+type DependencyCheck struct {
+	Upstream string
+	Path     string // endpoint de health del upstream
+	Critical bool   // si falla, el gateway no está listo
+}
+
+// HealthSettings controla las consultas de readiness
+type HealthSettings struct {
+	Timeout      time.Duration // por dependencia
+	CacheTTL     time.Duration // cuánto se reutiliza el último resultado
+	Dependencies []DependencyCheck
+}
+
+// DependencyHealth es el resultado de consultar una dependencia
+type DependencyHealth struct {
+	Status     string  `json:"status"`
+	Critical   bool    `json:"critical"`
+	URL        string  `json:"url"`
+	StatusCode int     `json:"statusCode,omitempty"`
+	LatencyMs  float64 `json:"latencyMs"`
+	Circuit    string  `json:"circuit"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// ReadinessReport es la respuesta de /health/ready
+type ReadinessReport struct {
+	Status       string                       `json:"status"`
+	Service      string                       `json:"service"`
+	Timestamp    string                       `json:"timestamp"`
+	CheckedAt    string                       `json:"checkedAt"`
+	Dependencies map[string]*DependencyHealth `json:"dependencies"`
+}
+
+// readinessCache guarda el último reporte para que los probes de Consul,
+// Prometheus y docker no multipliquen las llamadas a los upstreams. El mutex
+// se mantiene durante la consulta: las peticiones concurrentes esperan ese
+// resultado en vez de lanzar otra.
+type readinessCache struct {
+	mu      sync.Mutex
+	report  *ReadinessReport
+	expires time.Time
+}
+
+// readiness devuelve el reporte en caché o consulta las dependencias
+func (g *Gateway) readiness(ctx context.Context) *ReadinessReport {
+	g.readyCache.mu.Lock()
+	defer g.readyCache.mu.Unlock()
+
+	if g.readyCache.report != nil && time.Now().Before(g.readyCache.expires) {
+		return g.readyCache.report
+	}
+	report := g.checkDependencies(ctx)
+	if previous := g.readyCache.report; previous == nil || previous.Status != report.Status {
+		level := slog.LevelInfo
+		if report.Status != healthUp {
+			level = slog.LevelWarn
+		}
+		slog.Log(ctx, level, "Readiness changed", "status", report.Status, "dependencies", dependencyStatuses(report))
+	}
+	g.readyCache.report = report
+	g.readyCache.expires = time.Now().Add(g.config.Health.CacheTTL)
+	return report
+}
+
+// checkDependencies consulta todas las dependencias en paralelo. El estado
+// final es DOWN si falla una crítica y DEGRADED si falla una opcional.
+func (g *Gateway) checkDependencies(ctx context.Context) *ReadinessReport {
+	now := time.Now()
+	report := &ReadinessReport{
+		Status:       healthUp,
+		Service:      "api-gateway",
+		CheckedAt:    now.Format(time.RFC3339),
+		Dependencies: make(map[string]*DependencyHealth, len(g.config.Health.Dependencies)),
+	}
+
+	results := make([]*DependencyHealth, len(g.config.Health.Dependencies))
+	var wg sync.WaitGroup
+	for i, check := range g.config.Health.Dependencies {
+		wg.Add(1)
+		go func(i int, check DependencyCheck) {
+			defer wg.Done()
+			results[i] = g.checkDependency(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for i, check := range g.config.Health.Dependencies {
+		result := results[i]
+		report.Dependencies[check.Upstream] = result
+		if result.Status == healthUp {
+			continue
+		}
+		if check.Critical {
+			report.Status = healthDown
+		} else if report.Status == healthUp {
+			report.Status = healthDegraded
+		}
+	}
+	return report
+}
+
+// checkDependency hace un GET al endpoint de health del upstream. No pasa por
+// el circuit breaker: el resultado no debe abrirlo ni cerrarlo.
+func (g *Gateway) checkDependency(ctx context.Context, check DependencyCheck) *DependencyHealth {
+	result := &DependencyHealth{
+		Status:   healthDown,
+		Critical: check.Critical,
+		URL:      g.upstreamURL(check.Upstream) + check.Path,
+		Circuit:  g.breakers[check.Upstream].State().String(),
+	}
+
+	// Sin cancelación: la consulta queda en caché para otros probes aunque
+	// el que la pidió se desconecte
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), g.config.Health.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, result.URL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	setTraceHeaders(ctx, req.Header)
+	resp, err := g.httpClient.Do(req)
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		result.Status = healthUp
+	} else {
+		result.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return result
+}
+
+func dependencyStatuses(report *ReadinessReport) map[string]string {
+	statuses := make(map[string]string, len(report.Dependencies))
+	for name, dependency := range report.Dependencies {
+		statuses[name] = dependency.Status
+	}
+	return statuses
+}
+
+// ============================================
+// HANDLERS - HEALTH
+// ============================================
+
+// handleLiveness solo indica que el proceso responde; no consulta
+// dependencias para que una caída de auth no reinicie el gateway
+func (g *Gateway) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    healthUp,
+		"service":   "api-gateway",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// handleReadiness responde 200 si el gateway puede atender (UP o DEGRADED) y
+// 503 si falla una dependencia crítica o se está apagando
+func (g *Gateway) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if g.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    "SHUTTING_DOWN",
+			"service":   "api-gateway",
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+		return
+	}
+
+	// Copia para no modificar el reporte en caché
+	report := *g.readiness(r.Context())
+	report.Timestamp = time.Now().Format(time.RFC3339)
+	if report.Status == healthDown {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// healthUpstream responde a su endpoint de health con el status guardado en
+// status y cuenta las consultas
+type healthUpstream struct {
+	*httptest.Server
+	status atomic.Int32
+	calls  atomic.Int32
+}
+
+func newHealthUpstream(t *testing.T, path string, delay time.Duration) *healthUpstream {
+	t.Helper()
+	u := &healthUpstream{}
+	u.status.Store(http.StatusOK)
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		u.calls.Add(1)
+		time.Sleep(delay)
+		w.WriteHeader(int(u.status.Load()))
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func getReadiness(t *testing.T, handler http.Handler) (int, ReadinessReport) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/health/ready", nil))
+	var report ReadinessReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid readiness body %q", rec.Body.String())
+	}
+	return rec.Code, report
+}
+
+func TestReadinessClassifiesDependencies(t *testing.T) {
+	auth := newHealthUpstream(t, "/health", 0)
+	profiles := newHealthUpstream(t, "/health", 0)
+	// Consultas en paralelo: tres upstreams lentos no suman sus demoras
+	orchestrator := newHealthUpstream(t, "/actuator/health/ready", 150*time.Millisecond)
+
+	g := NewGateway(&Config{
+		JWTSecret:         testSecret,
+		AuthServiceURL:    auth.URL,
+		ProfileServiceURL: profiles.URL,
+		OrchestratorURL:   orchestrator.URL,
+		Health: HealthSettings{
+			Timeout:  time.Second,
+			CacheTTL: time.Hour,
+			Dependencies: []DependencyCheck{
+				{Upstream: "auth", Path: "/health", Critical: true},
+				{Upstream: "profiles", Path: "/health"},
+				{Upstream: "orchestrator", Path: "/actuator/health/ready"},
+			},
+		},
+	})
+	router := g.setupRoutes()
+
+	start := time.Now()
+	code, report := getReadiness(t, router)
+	if code != http.StatusOK || report.Status != healthUp {
+		t.Fatalf("readiness = %d %+v, want 200 UP", code, report)
+	}
+	if orch := report.Dependencies["orchestrator"]; orch.Status != healthUp || orch.Critical || orch.LatencyMs < 150 {
+		t.Errorf("orchestrator = %+v", orch)
+	}
+	if !report.Dependencies["auth"].Critical {
+		t.Error("auth must be critical")
+	}
+
+	// Dentro del TTL se reutiliza el resultado
+	getReadiness(t, router)
+	if calls := auth.calls.Load(); calls != 1 {
+		t.Fatalf("auth probed %d times, want the cached result", calls)
+	}
+
+	// profiles caído: DEGRADED pero sigue listo
+	profiles.status.Store(http.StatusInternalServerError)
+	g.readyCache.expires = time.Time{}
+	code, report = getReadiness(t, router)
+	if code != http.StatusOK || report.Status != healthDegraded || report.Dependencies["profiles"].StatusCode != 500 {
+		t.Fatalf("readiness = %d %+v, want 200 DEGRADED", code, report)
+	}
+
+	// auth caído: DOWN
+	auth.Close()
+	g.readyCache.expires = time.Time{}
+	code, report = getReadiness(t, router)
+	if code != http.StatusServiceUnavailable || report.Status != healthDown || report.Dependencies["auth"].Error == "" {
+		t.Fatalf("readiness = %d %+v, want 503 DOWN", code, report)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("probes took %v, want them concurrent", elapsed)
+	}
+
+	// Liveness no depende de los upstreams
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/health/live", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("liveness = %d", rec.Code)
+	}
+
+	// Durante el apagado readiness falla sin consultar upstreams
+	g.draining.Store(true)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/health/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readiness while draining = %d", rec.Code)
+	}
+}
+
+func TestReadinessTimesOutSlowDependency(t *testing.T) {
+	auth := newHealthUpstream(t, "/health", 500*time.Millisecond)
+	g := NewGateway(&Config{
+		JWTSecret:      testSecret,
+		AuthServiceURL: auth.URL,
+		Health: HealthSettings{
+			Timeout:      50 * time.Millisecond,
+			Dependencies: []DependencyCheck{{Upstream: "auth", Path: "/health", Critical: true}},
+		},
+	})
+
+	start := time.Now()
+	code, report := getReadiness(t, g.setupRoutes())
+	if code != http.StatusServiceUnavailable || report.Status != healthDown {
+		t.Fatalf("readiness = %d %+v, want 503 DOWN", code, report)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Fatalf("readiness took %v, want it bounded by the timeout", elapsed)
+	}
+}
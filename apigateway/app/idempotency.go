@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ============================================
+// IDEMPOTENCY-KEY
+// ============================================
+
+const (
+	idempotencyKeyHeader  = "Idempotency-Key"
+	idempotentReplayedHdr = "Idempotent-Replayed"
+	maxIdempotencyKeyLen  = 255
+)
+
+// IdempotencyRecord es la primera respuesta dada para una clave. Mientras la
+// petición original no termina, Done es false.
+type IdempotencyRecord struct {
+	Fingerprint string
+	Done        bool
+	StatusCode  int
+	Header      http.Header
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore guarda las respuestas por clave. Reserve es atómico: solo
+// una petición puede reservar una clave libre; las demás reciben el registro
+// existente.
+type IdempotencyStore interface {
+	// Reserve toma la clave si está libre. Si ya existe devuelve su registro
+	// y false.
+	Reserve(key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool)
+	// Complete guarda la respuesta de la petición que reservó la clave
+	Complete(key string, statusCode int, header http.Header, body []byte)
+	// Release libera la clave sin guardar respuesta (p. ej. tras un 5xx)
+	Release(key string)
+}
+
+// MemoryIdempotencyStore guarda las claves en memoria del proceso. Sirve con
+// una sola réplica del gateway; con varias hace falta un store compartido.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]*IdempotencyRecord
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: map[string]*IdempotencyRecord{}, now: time.Now}
+}
+
+func (s *MemoryIdempotencyStore) Reserve(key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	if record, ok := s.records[key]; ok && now.Before(record.ExpiresAt) {
+		copied := *record
+		return &copied, false
+	}
+	s.records[key] = &IdempotencyRecord{Fingerprint: fingerprint, ExpiresAt: now.Add(ttl)}
+	return nil, true
+}
+
+func (s *MemoryIdempotencyStore) Complete(key string, statusCode int, header http.Header, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.records[key]; ok {
+		record.Done = true
+		record.StatusCode = statusCode
+		record.Header = header
+		record.Body = body
+	}
+}
+
+func (s *MemoryIdempotencyStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+}
+
+// sweep borra las claves vencidas, como mucho una vez por minuto
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, record := range s.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+}
+
+// ============================================
+// MIDDLEWARE - IDEMPOTENCY-KEY
+// ============================================
+
+// idempotencyCaller identifica a quien hace la petición: el subject del token
+// si pasó por requireAuth, o la IP para rutas públicas como el registro
+func (g *Gateway) idempotencyCaller(r *http.Request) string {
+	if claims, ok := claimsFromContext(r.Context()); ok {
+		return "sub:" + claims.Subject
+	}
+	return "ip:" + g.clientIP(r)
+}
+
+// requestFingerprint resume método, ruta y body; la misma clave con otra
+// huella es un uso incorrecto de la clave
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// idempotent hace que una petición con Idempotency-Key se ejecute una sola
+// vez: los reintentos con la misma clave y el mismo body reciben la respuesta
+// guardada, y con otro body reciben 422. Sin la cabecera no hace nada.
+func (g *Gateway) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || g.idempotency == nil {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeJSONError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		body, ok := readRequestBody(w, r)
+		if !ok {
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		storeKey := g.idempotencyCaller(r) + "|" + key
+		fingerprint := requestFingerprint(r, body)
+
+		record, reserved := g.idempotency.Reserve(storeKey, fingerprint, g.config.IdempotencyTTL)
+		if !reserved {
+			switch {
+			case record.Fingerprint != fingerprint:
+				writeJSONError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+			case !record.Done:
+				writeJSONError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+			default:
+				slog.InfoContext(r.Context(), "Replaying stored response", "idempotency_key", key)
+				for name, values := range record.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set(idempotentReplayedHdr, "true")
+				w.WriteHeader(record.StatusCode)
+				w.Write(record.Body)
+			}
+			return
+		}
+
+		capture := &captureWriter{ResponseWriter: w, header: http.Header{}, statusCode: http.StatusOK}
+		completed := false
+		defer func() {
+			// Un 5xx (o un panic) puede ser transitorio: se libera la clave
+			// para que el cliente pueda reintentar de verdad
+			if !completed || capture.statusCode >= http.StatusInternalServerError {
+				g.idempotency.Release(storeKey)
+				return
+			}
+			header := capture.header.Clone()
+			header.Del(attemptsHeader)
+			g.idempotency.Complete(storeKey, capture.statusCode, header, capture.body.Bytes())
+		}()
+		next(capture, r)
+		if !capture.wroteHeader {
+			capture.WriteHeader(http.StatusOK)
+		}
+		completed = true
+	}
+}
+
+// captureWriter escribe la respuesta y además guarda una copia. Las cabeceras
+// del handler van a un mapa propio que se copia al enviar la respuesta: así
+// se guardan solo esas y no las que pusieron los middlewares de afuera (CORS,
+// rate limit, X-Request-ID), que son de cada petición.
+type captureWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (cw *captureWriter) Header() http.Header {
+	return cw.header
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if !cw.wroteHeader {
+		cw.statusCode = code
+		cw.wroteHeader = true
+		dst := cw.ResponseWriter.Header()
+		for name, values := range cw.header {
+			dst[name] = values
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
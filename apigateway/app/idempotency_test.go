@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKeyReplaysRegister(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error":"username taken"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"username":"bob"}`))
+	}))
+	defer upstream.Close()
+
+	g := NewGateway(&Config{AuthServiceURL: upstream.URL, IdempotencyTTL: time.Hour})
+	g.idempotency = NewMemoryIdempotencyStore()
+	g.routeTable = &RouteTable{Routes: []RouteConfig{{
+		Name: "register", Method: "POST", Path: "/api/v1/auth/register",
+		Upstream: "auth", UpstreamPath: "/accounts", Timeout: time.Second,
+	}}}
+	router := g.setupRoutes()
+
+	register := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/auth/register", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := register("k1", `{"username":"bob"}`)
+	replay := register("k1", `{"username":"bob"}`)
+	if first.Code != http.StatusCreated || replay.Code != http.StatusCreated {
+		t.Fatalf("statuses = %d, %d; want 201 twice", first.Code, replay.Code)
+	}
+	if replay.Body.String() != first.Body.String() || replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("replay = %q %v", replay.Body.String(), replay.Header())
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("upstream calls = %d, want 1", got)
+	}
+
+	if rec := register("k1", `{"username":"alice"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("different body: status = %d, want 422", rec.Code)
+	}
+	if rec := register("k2", `{"username":"bob"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("new key: status = %d, want upstream 409", rec.Code)
+	}
+}
+
+func TestIdempotencyKeyReleasedAfterServerError(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	g := NewGateway(&Config{IdempotencyTTL: time.Hour})
+	g.idempotency = store
+
+	status := http.StatusServiceUnavailable
+	handler := g.idempotent(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(status) })
+
+	for _, want := range []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusOK} {
+		req := httptest.NewRequest("POST", "/x", strings.NewReader("{}"))
+		req.Header.Set("Idempotency-Key", "k")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != want {
+			t.Fatalf("status = %d, want %d", rec.Code, want)
+		}
+		status = http.StatusOK
+	}
+}
+
+func TestIdempotencyReplayKeepsOnlyHandlerHeaders(t *testing.T) {
+	g := NewGateway(&Config{IdempotencyTTL: time.Hour})
+	g.idempotency = NewMemoryIdempotencyStore()
+
+	inner := g.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/accounts/bob")
+		w.WriteHeader(http.StatusCreated)
+	})
+	// Cabeceras de cada petición, como las de CORS o X-Request-ID
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+		w.Header().Set("X-Request-ID", r.Header.Get("X-Request-ID"))
+		inner(w, r)
+	}
+	call := func(origin, requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/x", strings.NewReader("{}"))
+		req.Header.Set("Idempotency-Key", "k")
+		req.Header.Set("Origin", origin)
+		req.Header.Set("X-Request-ID", requestID)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	first := call("https://a.example", "req-1")
+	if first.Header().Get("Location") != "/accounts/bob" || first.Header().Get("Access-Control-Allow-Origin") != "https://a.example" {
+		t.Fatalf("first response headers = %v", first.Header())
+	}
+
+	replay := call("https://b.example", "req-2")
+	want := map[string]string{
+		"Location":                    "/accounts/bob",
+		"Content-Type":                "application/json",
+		"Access-Control-Allow-Origin": "https://b.example",
+		"X-Request-ID":                "req-2",
+		"Idempotent-Replayed":         "true",
+	}
+	for name, value := range want {
+		if got := replay.Header().Get(name); got != value {
+			t.Errorf("replay %s = %q, want %q", name, got, value)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================
+// BLOQUEO DE LOGIN POR FUERZA BRUTA
+// ============================================
+
+// LockoutPolicy bloquea un identificador tras MaxFailures logins fallidos
+// dentro de Window, durante Cooldown
+type LockoutPolicy struct {
+	MaxFailures int
+	Window      time.Duration
+	Cooldown    time.Duration
+}
+
+// LoginAttemptStore guarda los fallos de login por identificador. La versión
+// en memoria vale para una réplica; para varias se implementa sobre un store
+// compartido.
+//
+// Cada intento se reserva antes de llamar a auth y se cierra después con
+// RecordFailure, Reset o Release. Así los intentos en curso cuentan contra el
+// límite y una ráfaga en paralelo no puede pasar toda antes del bloqueo.
+type LoginAttemptStore interface {
+	// Reserve anota un intento en curso. Si el identificador está bloqueado,
+	// o los fallos en la ventana más los intentos en curso ya llegan a
+	// MaxFailures, no reserva nada y devuelve cuánto esperar.
+	Reserve(ctx context.Context, identifier string, policy LockoutPolicy) (time.Duration, error)
+	// RecordFailure cierra el intento como fallo y devuelve los fallos en la
+	// ventana y si este fallo dejó bloqueado al identificador
+	RecordFailure(ctx context.Context, identifier string, policy LockoutPolicy) (int, bool, error)
+	// Reset cierra el intento tras un login correcto y borra los fallos
+	Reset(ctx context.Context, identifier string) error
+	// Release cierra el intento sin contarlo (auth no respondió 401 ni 200)
+	Release(ctx context.Context, identifier string) error
+}
+
+// inFlightRetry es el Retry-After cuando el límite lo ocupan intentos en curso:
+// en cuanto terminen se sabe si el identificador queda bloqueado o no
+const inFlightRetry = time.Second
+
+type loginAttempts struct {
+	failures    []time.Time
+	lockedUntil time.Time
+	inFlight    int
+}
+
+// MemoryLoginAttemptStore guarda los fallos en memoria del proceso
+type MemoryLoginAttemptStore struct {
+	mu        sync.Mutex
+	attempts  map[string]*loginAttempts
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{attempts: map[string]*loginAttempts{}, now: time.Now}
+}
+
+func (s *MemoryLoginAttemptStore) Reserve(_ context.Context, identifier string, policy LockoutPolicy) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now, policy.Window)
+	entry, ok := s.attempts[identifier]
+	if !ok {
+		entry = &loginAttempts{}
+		s.attempts[identifier] = entry
+	}
+	if remaining := entry.lockedUntil.Sub(now); remaining > 0 {
+		return remaining, nil
+	}
+	entry.pruneFailures(now, policy.Window)
+	if len(entry.failures)+entry.inFlight >= policy.MaxFailures {
+		return inFlightRetry, nil
+	}
+	entry.inFlight++
+	return 0, nil
+}
+
+func (s *MemoryLoginAttemptStore) RecordFailure(_ context.Context, identifier string, policy LockoutPolicy) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	entry := s.finish(identifier)
+	entry.pruneFailures(now, policy.Window)
+	entry.failures = append(entry.failures, now)
+
+	if len(entry.failures) >= policy.MaxFailures && !now.Before(entry.lockedUntil) {
+		entry.lockedUntil = now.Add(policy.Cooldown)
+		entry.failures = nil
+		return policy.MaxFailures, true, nil
+	}
+	return len(entry.failures), false, nil
+}
+
+func (s *MemoryLoginAttemptStore) Reset(_ context.Context, identifier string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.finish(identifier)
+	entry.failures = nil
+	entry.lockedUntil = time.Time{}
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) Release(_ context.Context, identifier string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finish(identifier)
+	return nil
+}
+
+// finish descuenta el intento en curso y devuelve la entrada
+func (s *MemoryLoginAttemptStore) finish(identifier string) *loginAttempts {
+	entry, ok := s.attempts[identifier]
+	if !ok {
+		entry = &loginAttempts{}
+		s.attempts[identifier] = entry
+	}
+	if entry.inFlight > 0 {
+		entry.inFlight--
+	}
+	return entry
+}
+
+// pruneFailures deja solo los fallos dentro de la ventana
+func (a *loginAttempts) pruneFailures(now time.Time, window time.Duration) {
+	recent := a.failures[:0]
+	for _, failure := range a.failures {
+		if now.Sub(failure) < window {
+			recent = append(recent, failure)
+		}
+	}
+	a.failures = recent
+}
+
+// sweep borra los identificadores sin intentos en curso, bloqueo vigente ni
+// fallos dentro de la ventana, como mucho una vez por minuto
+func (s *MemoryLoginAttemptStore) sweep(now time.Time, window time.Duration) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for identifier, entry := range s.attempts {
+		if entry.inFlight > 0 || now.Before(entry.lockedUntil) {
+			continue
+		}
+		if n := len(entry.failures); n == 0 || now.Sub(entry.failures[n-1]) >= window {
+			delete(s.attempts, identifier)
+		}
+	}
+}
+
+// loginIdentifier saca del body del login el username, que es lo que auth
+// autentica: otros campos (identifier, email) no se tienen en cuenta, o un
+// atacante podría cambiarlos en cada intento para no llegar nunca al
+// bloqueo. Se normaliza para que "Bob" y " bob" cuenten como el mismo.
+func loginIdentifier(body []byte) string {
+	var login struct {
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal(body, &login); err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(login.Username))
+}
+
+// ============================================
+// MIDDLEWARE - BLOQUEO DE LOGIN
+// ============================================
+
+// loginLockout cuenta los 401 que devuelve auth por identificador. Al llegar
+// a MaxFailures el identificador queda bloqueado (423) durante Cooldown y se
+// publica un evento de seguridad.
+func (g *Gateway) loginLockout(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if g.loginAttempts == nil {
+			next(w, r)
+			return
+		}
+
+		body, ok := readRequestBody(w, r)
+		if !ok {
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		identifier := loginIdentifier(body)
+		if identifier == "" {
+			next(w, r)
+			return
+		}
+
+		policy := g.config.LoginLockout
+		remaining, err := g.loginAttempts.Reserve(r.Context(), identifier, policy)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Login lockout store error, allowing attempt", "error", err)
+			next(w, r)
+			return
+		}
+		if remaining > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(remaining)))
+			writeJSONError(w, http.StatusLocked, "Too many failed login attempts, try again later")
+			return
+		}
+
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		settled := false
+		defer func() {
+			// Un panic del handler no deja el intento reservado para siempre
+			if !settled {
+				g.loginAttempts.Release(r.Context(), identifier)
+			}
+		}()
+		next(rw, r)
+		settled = true
+
+		// Solo cuentan las respuestas que vienen de auth (llevan la cabecera
+		// de intentos): una respuesta repetida por Idempotency-Key no es un
+		// intento nuevo
+		status := rw.statusCode
+		if rw.Header().Get(attemptsHeader) == "" {
+			status = 0
+		}
+		switch status {
+		case http.StatusUnauthorized:
+			failures, locked, err := g.loginAttempts.RecordFailure(r.Context(), identifier, policy)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error recording failed login", "error", err)
+				return
+			}
+			if locked {
+				slog.WarnContext(r.Context(), "Login locked", "identifier", identifier, "failures", failures)
+				g.publishLoginLockedEvent(r.Context(), identifier, g.clientIP(r), failures)
+			}
+		case http.StatusOK:
+			if err := g.loginAttempts.Reset(r.Context(), identifier); err != nil {
+				slog.ErrorContext(r.Context(), "Error resetting failed logins", "error", err)
+			}
+		default:
+			if err := g.loginAttempts.Release(r.Context(), identifier); err != nil {
+				slog.ErrorContext(r.Context(), "Error releasing login attempt", "error", err)
+			}
+		}
+	}
+}
+
+// publishLoginLockedEvent avisa por RabbitMQ (vía outbox) de un bloqueo
+func (g *Gateway) publishLoginLockedEvent(ctx context.Context, identifier, ip string, failures int) {
+	event := &DomainEvent{
+		ID:         newEventID(),
+		Type:       "security.login_locked",
+		RoutingKey: g.config.LoginLockedRoutingKey,
+		Data: map[string]interface{}{
+			"identifier":  identifier,
+			"ip":          ip,
+			"failures":    failures,
+			"lockedUntil": time.Now().Add(g.config.LoginLockout.Cooldown).Format(time.RFC3339),
+		},
+		Meta: traceMeta(ctx, map[string]interface{}{
+			"timestamp": time.Now().Format(time.RFC3339),
+			"source":    "api-gateway",
+		}),
+	}
+
+	if g.outbox == nil {
+		slog.WarnContext(ctx, "Event publishing disabled, dropping event", "event", "security.login_locked", "identifier", identifier)
+		return
+	}
+	if err := g.outbox.Enqueue(event); err != nil {
+		slog.ErrorContext(ctx, "Error storing event in outbox", "event", "security.login_locked", "error", err)
+	}
+}
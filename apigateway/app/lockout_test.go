@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoginLockoutAfterFailures(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), `"password":"right"`) {
+			w.Write([]byte(`{"access_token":"x"}`))
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"invalid credentials"}`))
+	}))
+	defer upstream.Close()
+
+	g := NewGateway(&Config{
+		AuthServiceURL: upstream.URL,
+		LoginLockout:   LockoutPolicy{MaxFailures: 3, Window: time.Minute, Cooldown: time.Minute},
+	})
+	g.loginAttempts = NewMemoryLoginAttemptStore()
+	g.outbox = NewOutboxDispatcher(openTestOutbox(t), &flakyPublisher{}, time.Hour, 3)
+	g.routeTable = &RouteTable{Routes: []RouteConfig{{
+		Name: "login", Method: "POST", Path: "/api/v1/auth/login",
+		Upstream: "auth", UpstreamPath: "/sessions", Timeout: time.Second, LoginLockout: true,
+	}}}
+	router := g.setupRoutes()
+
+	login := func(identifier, password string) int {
+		body := `{"username":"` + identifier + `","password":"` + password + `"}`
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/auth/login", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	// Un login correcto reinicia la cuenta de fallos
+	login("bob", "wrong")
+	login("bob", "wrong")
+	if got := login("bob", "right"); got != http.StatusOK {
+		t.Fatalf("correct login = %d", got)
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := login("Bob", "wrong"); got != http.StatusUnauthorized {
+			t.Fatalf("failure %d = %d, want 401", i, got)
+		}
+	}
+	before := atomic.LoadInt32(&calls)
+	if got := login("bob", "right"); got != http.StatusLocked {
+		t.Fatalf("login while locked = %d, want 423", got)
+	}
+	if atomic.LoadInt32(&calls) != before {
+		t.Fatal("locked attempt reached auth")
+	}
+	if got := login("alice", "wrong"); got != http.StatusUnauthorized {
+		t.Fatalf("other identifier = %d, want 401", got)
+	}
+
+	records, err := g.outbox.store.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Event.Type != "security.login_locked" {
+		t.Fatalf("outbox = %+v, want one security.login_locked event", records)
+	}
+	data, _ := json.Marshal(records[0].Event.Data)
+	if !strings.Contains(string(data), `"identifier":"bob"`) {
+		t.Fatalf("event data = %s", data)
+	}
+}
+
+func TestLoginLockoutIgnoresIdempotentReplays(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+
+	g := NewGateway(&Config{
+		AuthServiceURL: upstream.URL,
+		LoginLockout:   LockoutPolicy{MaxFailures: 2, Window: time.Minute, Cooldown: time.Minute},
+		IdempotencyTTL: time.Hour,
+	})
+	g.loginAttempts = NewMemoryLoginAttemptStore()
+	g.idempotency = NewMemoryIdempotencyStore()
+	g.routeTable = &RouteTable{Routes: []RouteConfig{{
+		Name: "login", Method: "POST", Path: "/api/v1/auth/login",
+		Upstream: "auth", UpstreamPath: "/sessions", Timeout: time.Second, LoginLockout: true,
+	}}}
+	router := g.setupRoutes()
+
+	// El mismo intento repetido con su Idempotency-Key recibe el 401 guardado
+	// y cuenta una sola vez
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("POST", "/api/v1/auth/login", strings.NewReader(`{"username":"bob","password":"wrong"}`))
+		req.Header.Set("Idempotency-Key", "k")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d = %d, want 401", i, rec.Code)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("auth calls = %d, want 1", got)
+	}
+	store := g.loginAttempts.(*MemoryLoginAttemptStore)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if entry := store.attempts["bob"]; len(entry.failures) != 1 || entry.inFlight != 0 {
+		t.Fatalf("attempts = %+v, want one failure and nothing in flight", entry)
+	}
+}
+
+func TestMemoryLoginAttemptStoreSweepsStaleEntries(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryLoginAttemptStore()
+	store.now = func() time.Time { return now }
+	policy := LockoutPolicy{MaxFailures: 2, Window: time.Minute, Cooldown: 10 * time.Minute}
+	ctx := context.Background()
+
+	fail := func(identifier string) {
+		store.Reserve(ctx, identifier, policy)
+		store.RecordFailure(ctx, identifier, policy)
+	}
+	fail("locked")
+	fail("locked")
+	fail("stale")
+
+	// Pasada la ventana se borran los fallos viejos; el bloqueo vigente queda
+	now = now.Add(2 * time.Minute)
+	fail("fresh")
+	store.mu.Lock()
+	_, hasStale := store.attempts["stale"]
+	_, hasLocked := store.attempts["locked"]
+	store.mu.Unlock()
+	if hasStale || !hasLocked {
+		t.Fatalf("after sweep: stale kept = %v, locked kept = %v", hasStale, hasLocked)
+	}
+
+	// Vencido el bloqueo también se borra
+	now = now.Add(10 * time.Minute)
+	fail("fresh")
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.attempts["locked"]; ok || len(store.attempts) != 1 {
+		t.Fatalf("entries after lock expired = %v", store.attempts)
+	}
+}
+
+func lockoutGateway(t *testing.T, upstream *httptest.Server, policy LockoutPolicy) http.Handler {
+	t.Helper()
+	g := NewGateway(&Config{AuthServiceURL: upstream.URL, LoginLockout: policy})
+	g.loginAttempts = NewMemoryLoginAttemptStore()
+	g.routeTable = &RouteTable{Routes: []RouteConfig{{
+		Name: "login", Method: "POST", Path: "/api/v1/auth/login",
+		Upstream: "auth", UpstreamPath: "/sessions", Timeout: 5 * time.Second, LoginLockout: true,
+	}}}
+	return g.setupRoutes()
+}
+
+func TestLoginLockoutKeysOnUsername(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+	router := lockoutGateway(t, upstream, LockoutPolicy{MaxFailures: 3, Window: time.Minute, Cooldown: time.Minute})
+
+	// Cambiar identifier o email en cada intento no evita el bloqueo de bob
+	statuses := []int{}
+	for i := 0; i < 4; i++ {
+		body := `{"username":"bob","identifier":"junk-` + strconv.Itoa(i) + `","email":"x` + strconv.Itoa(i) + `@x.io","password":"wrong"}`
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/auth/login", strings.NewReader(body)))
+		statuses = append(statuses, rec.Code)
+	}
+	if statuses[2] != http.StatusUnauthorized || statuses[3] != http.StatusLocked {
+		t.Fatalf("statuses = %v, want bob locked after 3 failures", statuses)
+	}
+}
+
+func TestLoginLockoutConcurrentBurst(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+	router := lockoutGateway(t, upstream, LockoutPolicy{MaxFailures: 3, Window: time.Minute, Cooldown: time.Minute})
+
+	// Veinte intentos a la vez: solo MaxFailures llegan a auth
+	var wg sync.WaitGroup
+	var locked int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/auth/login", strings.NewReader(`{"username":"bob","password":"wrong"}`)))
+			if rec.Code == http.StatusLocked {
+				atomic.AddInt32(&locked, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Fatalf("auth calls = %d, want 3", got)
+	}
+	if got := atomic.LoadInt32(&locked); got != 17 {
+		t.Fatalf("locked responses = %d, want 17", got)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/auth/login", strings.NewReader(`{"username":"bob","password":"right"}`)))
+	if rec.Code != http.StatusLocked {
+		t.Fatalf("after the burst: status = %d, want 423", rec.Code)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ============================================
+// LOGGING ESTRUCTURADO
+// ============================================
+
+// LoggingConfig elige nivel y formato de los logs. En JSON las claves siguen
+// lo que espera promtail (timestamp, level, service, message).
+type LoggingConfig struct {
+	Level   slog.Level
+	Format  string // "json" (por defecto) o "text" para desarrollo local
+	Service string
+}
+
+// parseLogLevel acepta debug, info, warn o error (sin importar mayúsculas)
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("invalid log level %q", value)
+	}
+	return level, nil
+}
+
+// NewLogger crea el logger del gateway. Cada línea lleva el service y, si el
+// contexto es de una petición, su request id, trace id y usuario.
+func NewLogger(w io.Writer, config LoggingConfig) *slog.Logger {
+	options := &slog.HandlerOptions{Level: config.Level, ReplaceAttr: replaceLogAttr}
+	var handler slog.Handler
+	if config.Format == "text" {
+		handler = slog.NewTextHandler(w, options)
+	} else {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	return slog.New(contextHandler{handler}).With("service", config.Service)
+}
+
+// redacted reemplaza los valores sensibles en los logs
+const redacted = "[REDACTED]"
+
+// sensitiveLogKeys son claves que nunca se escriben tal cual, ni en atributos
+// ni dentro de cuerpos JSON
+var sensitiveLogKeys = map[string]bool{
+	"authorization": true,
+	"cookie":        true,
+	"set-cookie":    true,
+	"password":      true,
+	"newpassword":   true,
+	"new_password":  true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"accesstoken":   true,
+	"refreshtoken":  true,
+	"secret":        true,
+}
+
+func isSensitiveLogKey(key string) bool {
+	return sensitiveLogKeys[strings.ToLower(key)]
+}
+
+// replaceLogAttr renombra time y msg como los espera promtail y oculta los
+// valores sensibles
+func replaceLogAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 {
+		switch a.Key {
+		case slog.TimeKey:
+			return slog.Time("timestamp", a.Value.Time().UTC())
+		case slog.MessageKey:
+			a.Key = "message"
+			return a
+		}
+	}
+	if isSensitiveLogKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// logHeaders arma un grupo con las cabeceras; al ser atributos sueltos pasan
+// por replaceLogAttr y Authorization o Cookie quedan ocultas
+func logHeaders(header http.Header) slog.Attr {
+	attrs := make([]interface{}, 0, len(header))
+	for key, values := range header {
+		attrs = append(attrs, slog.String(key, strings.Join(values, ", ")))
+	}
+	return slog.Group("headers", attrs...)
+}
+
+// ============================================
+// LOGGING - CONTEXTO DE LA PETICIÓN
+// ============================================
+
+// requestLogInfo guarda datos que se conocen a mitad de la petición (el
+// usuario lo descubre requireAuth) para agregarlos a todas sus líneas
+type requestLogInfo struct {
+	user atomic.Value // string
+}
+
+type requestLogKey struct{}
+
+func withRequestLogInfo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestLogKey{}, &requestLogInfo{})
+}
+
+// setLogUser registra el usuario autenticado de la petición
+func setLogUser(ctx context.Context, user string) {
+	if info, ok := ctx.Value(requestLogKey{}).(*requestLogInfo); ok {
+		info.user.Store(user)
+	}
+}
+
+func logUser(ctx context.Context) string {
+	if info, ok := ctx.Value(requestLogKey{}).(*requestLogInfo); ok {
+		user, _ := info.user.Load().(string)
+		return user
+	}
+	return ""
+}
+
+// contextHandler agrega request_id, trace_id y user desde el contexto
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if tc, ok := traceFromContext(ctx); ok {
+		record.AddAttrs(slog.String("request_id", tc.RequestID), slog.String("trace_id", tc.TraceID))
+	}
+	if user := logUser(ctx); user != "" {
+		record.AddAttrs(slog.String("user", user))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// ============================================
+// MIDDLEWARE - LOGGING
+// ============================================
+
+// loggingMiddleware escribe una línea por petición con ruta, método, status y
+// duración. El nivel depende del status: 5xx es error y 4xx es warn.
+func (g *Gateway) loggingMiddleware(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := withRequestLogInfo(r.Context())
+		route := routeTemplate(router, r)
+		slog.DebugContext(ctx, "Request started",
+			"route", route,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote_addr", r.RemoteAddr,
+			logHeaders(r.Header),
+		)
+
+		// Crear un ResponseWriter personalizado para capturar el status code
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		switch {
+		case rw.statusCode >= 500:
+			level = slog.LevelError
+		case rw.statusCode >= 400:
+			level = slog.LevelWarn
+		}
+		slog.Log(ctx, level, "Request completed",
+			"route", route,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rw.statusCode,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+		)
+	})
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	rw.statusCode = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs redirige el logger por defecto a un buffer durante el test
+func captureLogs(t *testing.T, config LoggingConfig) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(NewLogger(&buf, config))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line is not JSON: %q", line)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for value, want := range map[string]slog.Level{"debug": slog.LevelDebug, "INFO": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError} {
+		if got, err := parseLogLevel(value); err != nil || got != want {
+			t.Errorf("parseLogLevel(%q) = %v, %v", value, got, err)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+func TestLoggerFormatAndRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LoggingConfig{Level: slog.LevelInfo, Service: "api-gateway"})
+
+	ctx := withRequestLogInfo(withTraceContext(context.Background(), TraceContext{RequestID: "req-1", TraceID: "4bf92f3577b34da6a3ce929d0e0e4736"}))
+	setLogUser(ctx, "bob")
+	header := http.Header{"Authorization": {"Bearer secret-token"}, "Accept": {"application/json"}}
+	logger.InfoContext(ctx, "Proxying", logHeaders(header), "identifier", "bob", "password", "hunter2")
+	logger.DebugContext(ctx, "Hidden at info level")
+
+	lines := logLines(t, &buf)
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1: %s", len(lines), buf.String())
+	}
+	entry := lines[0]
+	for key, want := range map[string]string{"level": "INFO", "message": "Proxying", "service": "api-gateway", "request_id": "req-1", "trace_id": "4bf92f3577b34da6a3ce929d0e0e4736", "user": "bob"} {
+		if entry[key] != want {
+			t.Errorf("%s = %v, want %q", key, entry[key], want)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, entry["timestamp"].(string)); err != nil {
+		t.Errorf("timestamp = %v", entry["timestamp"])
+	}
+	if strings.Contains(buf.String(), "secret-token") || strings.Contains(buf.String(), "hunter2") {
+		t.Fatalf("sensitive values leaked: %s", buf.String())
+	}
+	headers := entry["headers"].(map[string]interface{})
+	if headers["Authorization"] != redacted || headers["Accept"] != "application/json" ||
+		entry["password"] != redacted || entry["identifier"] != "bob" {
+		t.Errorf("unexpected redaction: headers=%v entry=%v", headers, entry)
+	}
+
+	buf.Reset()
+	text := NewLogger(&buf, LoggingConfig{Level: slog.LevelInfo, Format: "text", Service: "api-gateway"})
+	text.Info("Started", "password", "hunter2")
+	if out := buf.String(); !strings.Contains(out, "message=Started") || !strings.Contains(out, "password="+redacted) {
+		t.Errorf("text output = %q", out)
+	}
+}
+
+func TestLoggingMiddlewareFields(t *testing.T) {
+	buf := captureLogs(t, LoggingConfig{Level: slog.LevelInfo, Service: "api-gateway"})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	g := NewGateway(&Config{JWTSecret: testSecret, AuthServiceURL: upstream.URL})
+	router := g.setupRoutes()
+	handler := g.tracingMiddleware(router, g.loggingMiddleware(router, router))
+	token := signTestToken(t, testSecret, map[string]interface{}{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+
+	req := httptest.NewRequest("DELETE", "/api/v1/users/bob", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(requestIDHeader, "req-9")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var completed map[string]interface{}
+	for _, entry := range logLines(t, buf) {
+		if entry["request_id"] != "req-9" {
+			t.Errorf("line without request id: %v", entry)
+		}
+		if entry["message"] == "Request completed" {
+			completed = entry
+		}
+	}
+	if completed == nil {
+		t.Fatalf("no completion line in %s", buf.String())
+	}
+	if completed["level"] != "ERROR" || completed["route"] != "/api/v1/users/{username}" || completed["method"] != "DELETE" ||
+		completed["status"] != float64(500) || completed["user"] != "bob" {
+		t.Errorf("completion line = %v", completed)
+	}
+	if _, ok := completed["duration_ms"].(float64); !ok {
+		t.Errorf("duration_ms = %v", completed["duration_ms"])
+	}
+	if strings.Contains(buf.String(), token) {
+		t.Error("token leaked into logs")
+	}
+}
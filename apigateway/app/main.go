@@ -2,17 +2,28 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ============================================
@@ -25,6 +36,47 @@ type Config struct {
 	ProfileServiceURL string // Futuro servicio de perfiles
 	OrchestratorURL   string
 	JWTSecret         string
+	JWTIssuer         string
+	JWTAudience       string
+	JWTClockSkew      time.Duration
+	RoutesFile        string
+	CompositionsFile  string
+	CORSFile          string
+	ConsulAddr        string // vacío = sin descubrimiento, se usan las URLs fijas
+	ConsulServices    map[string]string
+	ConsulWait        time.Duration
+	ConsulRegister    bool
+	ServiceName       string
+	ServiceID         string
+	ServiceAddress    string
+	MetricsPath       string
+
+	RabbitMQURL           string
+	EventsExchange        string
+	UserDeletedRoutingKey string
+	LoginLockedRoutingKey string
+	OutboxPath            string
+	OutboxPollInterval    time.Duration
+	OutboxMaxAttempts     int
+
+	UnifiedUpdateSaga bool
+	Breaker           BreakerSettings
+	Retry             RetryPolicy // política por defecto; cada ruta puede cambiarla
+	RetryBudgetRatio  float64
+	RetryBudgetMax    int
+	IdempotencyTTL    time.Duration
+	RateLimitEnabled  bool
+	RateLimitsFile    string
+	RateLimitRedis    string // host:port; vacío = contadores en memoria
+	LoginLockout      LockoutPolicy
+	Tracing           TracingConfig
+	Logging           LoggingConfig
+	Shutdown          ShutdownSettings
+	Health            HealthSettings
+	TrustedProxies    TrustedProxies // proxies cuyas cabeceras X-Forwarded-* se conservan
+	PathParamPattern  *regexp.Regexp // valores aceptados en {variables} de las URLs de upstream
+	MaxRequestBytes   int64          // 0 = sin límite
+	MaxResponseBytes  int64          // 0 = sin límite
 }
 
 type ServiceResponse struct {
@@ -32,6 +84,7 @@ type ServiceResponse struct {
 	Body       []byte
 	Headers    http.Header
 	Error      error
+	Attempts   int
 }
 
 type UnifiedUserResponse struct {
@@ -59,179 +112,89 @@ type UnifiedUserResponse struct {
 // ============================================
 
 type Gateway struct {
-	config     *Config
-	httpClient *http.Client
+	config        *Config
+	httpClient    *http.Client
+	jwtValidator  *JWTValidator
+	routeTable    *RouteTable
+	compositions  map[string]*Composition
+	discovery     *ServiceDiscovery
+	metrics       *gatewayMetrics
+	outbox        *OutboxDispatcher
+	breakers      map[string]*CircuitBreaker
+	retryBudgets  map[string]*RetryBudget
+	idempotency   IdempotencyStore
+	rateLimiter   *RateLimiter
+	loginAttempts LoginAttemptStore
+	cors          *CORSPolicy
+
+	tracerProvider trace.TracerProvider
+	draining       atomic.Bool // true desde que empieza el apagado
+	readyCache     readinessCache
 }
 
 func NewGateway(config *Config) *Gateway {
-	return &Gateway{
+	g := &Gateway{
 		config: config,
-		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+		// Sin timeout global: cada llamada lleva el deadline de su ruta en el
+		// contexto y se corta si el cliente se va
+		httpClient:   &http.Client{},
+		jwtValidator: NewJWTValidator(config),
+		metrics:      newGatewayMetrics(),
+		// Sin exportador: los spans solo sirven para propagar los ids
+		tracerProvider: sdktrace.NewTracerProvider(),
 	}
+	g.breakers = g.newBreakers(config.Breaker)
+	g.retryBudgets = newRetryBudgets(config.RetryBudgetRatio, config.RetryBudgetMax)
+	return g
 }
 
 // ============================================
-// MIDDLEWARE - LOGGING
-// ============================================
-
-func (g *Gateway) loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		log.Printf("[%s] %s %s - Started", r.Method, r.URL.Path, r.RemoteAddr)
-
-		// Crear un ResponseWriter personalizado para capturar el status code
-		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
-
-		next.ServeHTTP(rw, r)
-
-		duration := time.Since(start)
-		log.Printf("[%s] %s - Status: %d - Duration: %v", r.Method, r.URL.Path, rw.statusCode, duration)
-	})
-}
-
-type responseWriter struct {
-	http.ResponseWriter
-	statusCode int
-}
-
-func (rw *responseWriter) WriteHeader(code int) {
-	rw.statusCode = code
-	rw.ResponseWriter.WriteHeader(code)
-}
-
-// ============================================
-// MIDDLEWARE - CORS
+// PROXY HELPER
 // ============================================
 
-func (g *Gateway) corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
+// proxyRequest llama al upstream y lee la respuesta completa. La usan los
+// handlers que necesitan el body (operaciones unificadas, composiciones,
+// saga); las rutas de la tabla usan el proxy con streaming de proxy.go.
+// Attempts cuenta las llamadas hechas.
+func (g *Gateway) proxyRequest(upstream, targetURL string, r *http.Request, body []byte) *ServiceResponse {
+	header := http.Header{}
+	copyRequestHeaders(header, r.Header)
+	return g.bufferedRequest(upstream, r, r.Method, targetURL, header, body)
 }
 
-// ============================================
-// PROXY HELPER
-// ============================================
-
-func (g *Gateway) proxyRequest(targetURL string, r *http.Request, body []byte) *ServiceResponse {
-	// Crear nueva request
-	req, err := http.NewRequest(r.Method, targetURL, bytes.NewReader(body))
+// bufferedRequest envía una petición armada por el gateway en nombre de r
+// (el cliente) y lee la respuesta completa
+func (g *Gateway) bufferedRequest(upstream string, r *http.Request, method, targetURL string, header http.Header, body []byte) *ServiceResponse {
+	req, err := http.NewRequestWithContext(r.Context(), method, targetURL, bytes.NewReader(body))
 	if err != nil {
 		return &ServiceResponse{Error: err}
 	}
+	req.Header = header
+	g.setForwardedHeaders(req.Header, r)
 
-	// Copiar headers
-	for key, values := range r.Header {
-		for _, value := range values {
-			req.Header.Add(key, value)
-		}
-	}
-
-	// Ejecutar request
-	resp, err := g.httpClient.Do(req)
+	resp, attempts, err := g.callUpstream(upstream, req)
 	if err != nil {
-		return &ServiceResponse{Error: err}
+		return &ServiceResponse{Error: err, Attempts: attempts}
 	}
 	defer resp.Body.Close()
 
-	// Leer respuesta
-	responseBody, err := io.ReadAll(resp.Body)
+	responseBody, err := readLimited(resp.Body, resp.ContentLength, g.config.MaxResponseBytes)
 	if err != nil {
-		return &ServiceResponse{Error: err}
+		return &ServiceResponse{Error: fmt.Errorf("%s: %w", upstream, err), Attempts: attempts}
 	}
-
 	return &ServiceResponse{
 		StatusCode: resp.StatusCode,
 		Body:       responseBody,
 		Headers:    resp.Header,
+		Attempts:   attempts,
 	}
 }
 
-// ============================================
-// HANDLER - AUTENTICACIÓN (LOGIN)
-// ============================================
-
-func (g *Gateway) handleLogin(w http.ResponseWriter, r *http.Request) {
-	log.Println("[Gateway] Processing login request")
-
-	// Leer body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
-		return
-	}
-
-	// Proxy al servicio de autenticación
-	targetURL := g.config.AuthServiceURL + "/sessions"
-	resp := g.proxyRequest(targetURL, r, body)
-
-	if resp.Error != nil {
-		log.Printf("[Gateway] Error proxying to auth service: %v", resp.Error)
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
-		return
-	}
-
-	// Copiar headers de respuesta
-	for key, values := range resp.Headers {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
-	}
-
-	// Enviar respuesta
-	w.WriteHeader(resp.StatusCode)
-	w.Write(resp.Body)
-
-	log.Printf("[Gateway] Login request completed - Status: %d", resp.StatusCode)
-}
-
-// ============================================
-// HANDLER - REGISTRO
-// ============================================
-
-func (g *Gateway) handleRegister(w http.ResponseWriter, r *http.Request) {
-	log.Println("[Gateway] Processing register request")
-
-	// Leer body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
-		return
-	}
-
-	// Proxy al servicio de autenticación
-	targetURL := g.config.AuthServiceURL + "/accounts"
-	resp := g.proxyRequest(targetURL, r, body)
-
-	if resp.Error != nil {
-		log.Printf("[Gateway] Error proxying to auth service: %v", resp.Error)
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
-		return
-	}
-
-	// Copiar headers de respuesta
-	for key, values := range resp.Headers {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
-	}
-
-	// Enviar respuesta
-	w.WriteHeader(resp.StatusCode)
-	w.Write(resp.Body)
-
-	log.Printf("[Gateway] Register request completed - Status: %d", resp.StatusCode)
+// writeJSONError responde con el formato de error estándar {"error": "..."}
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
 
 // ============================================
@@ -242,80 +205,71 @@ func (g *Gateway) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	username := vars["username"]
 
-	log.Printf("[Gateway] Processing delete user request for: %s", username)
+	slog.InfoContext(r.Context(), "Processing delete user request", "username", username)
 
-	// Extraer token de autorización
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, `{"error":"Authorization header required"}`, http.StatusUnauthorized)
+	targetURL, err := g.upstreamTarget("auth", "/accounts/{username}", vars, nil)
+	if err != nil {
+		writeUpstreamTargetError(w, r, err)
+		return
+	}
+
+	// Solo el dueño de la cuenta o un admin puede eliminarla
+	if !g.authorizeUserAccess(w, r, username) {
 		return
 	}
 
 	// Proxy al servicio de autenticación
-	targetURL := g.config.AuthServiceURL + "/accounts/" + username
-	resp := g.proxyRequest(targetURL, r, nil)
+	resp := g.proxyRequest("auth", targetURL, r, nil)
+	setAttemptsHeader(w, resp)
 
 	if resp.Error != nil {
-		log.Printf("[Gateway] Error proxying delete request: %v", resp.Error)
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		if !upstreamCanceled(resp.Error) {
+			slog.ErrorContext(r.Context(), "Error proxying delete request", "upstream", "auth", "error", resp.Error)
+		}
+		writeUpstreamError(w, resp.Error)
 		return
 	}
 
 	// Si la eliminación fue exitosa, publicar evento
 	if resp.StatusCode == 200 {
-		go g.publishUserDeletedEvent(username, authHeader)
+		g.publishUserDeletedEvent(r.Context(), username)
 	}
 
 	// Copiar headers de respuesta
-	for key, values := range resp.Headers {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
-	}
+	copyResponseHeaders(w.Header(), resp.Headers)
 
 	// Enviar respuesta
 	w.WriteHeader(resp.StatusCode)
 	w.Write(resp.Body)
 
-	log.Printf("[Gateway] Delete user request completed - Status: %d", resp.StatusCode)
+	slog.InfoContext(r.Context(), "Delete user request completed", "username", username, "upstream", "auth", "status", resp.StatusCode)
 }
 
-// Publicar evento de usuario eliminado al orquestador
-func (g *Gateway) publishUserDeletedEvent(username string, authHeader string) {
-	eventData := map[string]interface{}{
-		"type": "user.deleted",
-		"data": map[string]string{
+// Publicar evento de usuario eliminado: se guarda en el outbox antes de
+// responder y el dispatcher lo entrega a RabbitMQ (exchange de eventos de auth)
+func (g *Gateway) publishUserDeletedEvent(ctx context.Context, username string) {
+	event := &DomainEvent{
+		ID:         newEventID(),
+		Type:       "user.deleted",
+		RoutingKey: g.config.UserDeletedRoutingKey,
+		Data: map[string]interface{}{
 			"username": username,
 		},
-		"meta": map[string]string{
+		Meta: traceMeta(ctx, map[string]interface{}{
 			"timestamp": time.Now().Format(time.RFC3339),
 			"source":    "api-gateway",
-		},
-	}
-
-	jsonData, err := json.Marshal(eventData)
-	if err != nil {
-		log.Printf("[Gateway] Error marshaling user.deleted event: %v", err)
-		return
+		}),
 	}
 
-	req, err := http.NewRequest("POST", g.config.OrchestratorURL+"/orchestrator/user-deleted", bytes.NewReader(jsonData))
-	if err != nil {
-		log.Printf("[Gateway] Error creating user.deleted event request: %v", err)
+	if g.outbox == nil {
+		slog.WarnContext(ctx, "Event publishing disabled, dropping event", "event", "user.deleted", "username", username)
 		return
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", authHeader)
-
-	resp, err := g.httpClient.Do(req)
-	if err != nil {
-		log.Printf("[Gateway] Error sending user.deleted event: %v", err)
+	if err := g.outbox.Enqueue(event); err != nil {
+		slog.ErrorContext(ctx, "Error storing event in outbox", "event", "user.deleted", "username", username, "error", err)
 		return
 	}
-	defer resp.Body.Close()
-
-	log.Printf("[Gateway] user.deleted event published - Status: %d", resp.StatusCode)
+	slog.InfoContext(ctx, "Event stored in outbox", "event", "user.deleted", "username", username)
 }
 
 // ============================================
@@ -326,126 +280,18 @@ func (g *Gateway) handleGetUserUnified(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	username := vars["username"]
 
-	log.Printf("[Gateway] Processing unified GET user request for: %s", username)
-
-	// Extraer token de autorización
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, `{"error":"Authorization header required"}`, http.StatusUnauthorized)
-		return
-	}
-
-	// Canal para recibir respuestas
-	type ServiceResult struct {
-		Name     string
-		Response *ServiceResponse
-	}
-	resultChan := make(chan ServiceResult, 2)
-	var wg sync.WaitGroup
-
-	// Consultar servicio de autenticación
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		targetURL := g.config.AuthServiceURL + "/accounts/" + username
-		resp := g.proxyRequest(targetURL, r, nil)
-		resultChan <- ServiceResult{Name: "auth", Response: resp}
-	}()
-
-	// Consultar servicio de perfiles
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		targetURL := g.config.ProfileServiceURL + "/profiles/" + username
-		resp := g.proxyRequest(targetURL, r, nil)
-		resultChan <- ServiceResult{Name: "profile", Response: resp}
-	}()
-
-	// Esperar respuestas
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
-
-	// Recolectar respuestas
-	results := make(map[string]*ServiceResponse)
-	for result := range resultChan {
-		results[result.Name] = result.Response
-	}
-
-	// Verificar respuesta de auth
-	authResp := results["auth"]
-	if authResp == nil || authResp.Error != nil {
-		log.Printf("[Gateway] Error getting auth data: %v", authResp.Error)
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
-		return
-	}
-
-	if authResp.StatusCode != 200 {
-		w.WriteHeader(authResp.StatusCode)
-		w.Write(authResp.Body)
-		return
-	}
+	slog.InfoContext(r.Context(), "Processing unified GET user request", "username", username)
 
-	// Parsear respuesta de auth
-	var authData map[string]interface{}
-	if err := json.Unmarshal(authResp.Body, &authData); err != nil {
-		log.Printf("[Gateway] Error parsing auth response: %v", err)
-		http.Error(w, "Error processing response", http.StatusInternalServerError)
+	// Qué upstreams se consultan y cómo se combinan sale de config/compositions.yaml
+	composition, ok := g.compositions[unifiedUserComposition]
+	if !ok {
+		slog.ErrorContext(r.Context(), "Composition is not configured", "composition", unifiedUserComposition)
+		writeJSONError(w, http.StatusInternalServerError, "Unified view is not configured")
 		return
 	}
+	g.compose(w, r, composition)
 
-	// Combinar con datos de perfil si están disponibles
-	unifiedResponse := authData
-
-	if profileResp := results["profile"]; profileResp != nil && profileResp.StatusCode == 200 {
-		var profileData map[string]interface{}
-		if err := json.Unmarshal(profileResp.Body, &profileData); err == nil {
-			log.Printf("[Gateway] Successfully retrieved profile data for %s", username)
-
-			// Obtener el objeto user de auth
-			if userObj, ok := unifiedResponse["user"].(map[string]interface{}); ok {
-				// Agregar campos adicionales del perfil
-				if bio, ok := profileData["bio"]; ok {
-					userObj["bio"] = bio
-				}
-				if nickname, ok := profileData["nickname"]; ok {
-					userObj["nickname"] = nickname
-				}
-				if personalUrl, ok := profileData["personal_url"]; ok {
-					userObj["personalUrl"] = personalUrl
-				}
-				if organization, ok := profileData["organization"]; ok {
-					userObj["organization"] = organization
-				}
-				if country, ok := profileData["country"]; ok {
-					userObj["country"] = country
-				}
-				if profileVisibility, ok := profileData["profile_visibility"]; ok {
-					userObj["profileVisibility"] = profileVisibility
-				}
-				// Agregar URLs sociales
-				if githubUrl, ok := profileData["github_url"]; ok {
-					userObj["githubUrl"] = githubUrl
-				}
-				if linkedinUrl, ok := profileData["linkedin_url"]; ok {
-					userObj["linkedinUrl"] = linkedinUrl
-				}
-				if twitterUrl, ok := profileData["twitter_url"]; ok {
-					userObj["twitterUrl"] = twitterUrl
-				}
-			}
-		} else {
-			log.Printf("[Gateway] Warning: Could not parse profile data for %s", username)
-		}
-	} else {
-		log.Printf("[Gateway] Profile data not available or service returned error for %s", username)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(unifiedResponse)
-
-	log.Printf("[Gateway] Unified GET user request completed successfully")
+	slog.InfoContext(r.Context(), "Unified GET user request completed", "username", username)
 }
 
 // ============================================
@@ -456,19 +302,25 @@ func (g *Gateway) handleUpdateUserUnified(w http.ResponseWriter, r *http.Request
 	vars := mux.Vars(r)
 	username := vars["username"]
 
-	log.Printf("[Gateway] Processing unified UPDATE user request for: %s", username)
+	slog.InfoContext(r.Context(), "Processing unified UPDATE user request", "username", username)
 
-	// Extraer token de autorización
+	// Token ya validado por requireAuth
 	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, `{"error":"Authorization header required"}`, http.StatusUnauthorized)
+
+	// El username va en la URL de auth: se valida antes de escribir nada
+	if _, err := g.accountURL(username); err != nil {
+		writeUpstreamTargetError(w, r, err)
+		return
+	}
+
+	// Solo el dueño de la cuenta o un admin puede modificarla
+	if !g.authorizeUserAccess(w, r, username) {
 		return
 	}
 
 	// Leer body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
+	body, ok := readRequestBody(w, r)
+	if !ok {
 		return
 	}
 
@@ -504,11 +356,54 @@ func (g *Gateway) handleUpdateUserUnified(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	// Convertir campos al formato snake_case que espera el servicio de profiles
+	profileFieldsSnake := make(map[string]interface{})
+	fieldMapping := map[string]string{
+		"personalUrl":       "personal_url",
+		"mailingAddress":    "mailing_address",
+		"contactInfoPublic": "contact_info_public",
+		"profileVisibility": "profile_visibility",
+		"githubUrl":         "github_url",
+		"linkedinUrl":       "linkedin_url",
+		"twitterUrl":        "twitter_url",
+		"facebookUrl":       "facebook_url",
+		"instagramUrl":      "instagram_url",
+		"websiteUrl":        "website_url",
+	}
+	for key, val := range profileFields {
+		if snakeKey, exists := fieldMapping[key]; exists {
+			profileFieldsSnake[snakeKey] = val
+		} else {
+			profileFieldsSnake[key] = val
+		}
+	}
+
+	// profiles solo expone la escritura del perfil propio (/profiles/me con el
+	// token del cliente): un admin puede modificar la cuenta de otro usuario,
+	// pero no su perfil
+	if claims, _ := claimsFromContext(r.Context()); len(profileFieldsSnake) > 0 && !claims.Owns(username) {
+		slog.WarnContext(r.Context(), "Profile update by non-owner rejected", "username", username)
+		writeJSONError(w, http.StatusForbidden, "Profile fields can only be updated by the profile owner")
+		return
+	}
+
+	// Modo saga: si se escriben los dos servicios, guardar antes los valores
+	// actuales para poder compensar si uno de los dos falla
+	var snapshot *userSnapshot
+	var err error
+	if g.config.UnifiedUpdateSaga && len(authFields) > 0 && len(profileFieldsSnake) > 0 {
+		snapshot, err = g.snapshotUserFields(r, username, authHeader, authFields, profileFieldsSnake)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Could not snapshot user before update", "username", username, "error", err)
+			writeJSONError(w, http.StatusServiceUnavailable, "Could not read current user state, update not applied")
+			return
+		}
+	}
+
 	// Canal para recibir respuestas
 	type ServiceResult struct {
 		Name     string
 		Response *ServiceResponse
-		Error    error
 	}
 	resultChan := make(chan ServiceResult, 2)
 	var wg sync.WaitGroup
@@ -518,67 +413,17 @@ func (g *Gateway) handleUpdateUserUnified(w http.ResponseWriter, r *http.Request
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			authBody, _ := json.Marshal(authFields)
-			targetURL := g.config.AuthServiceURL + "/accounts/" + username
-
-			// Crear request PATCH
-			req, err := http.NewRequest("PATCH", targetURL, bytes.NewReader(authBody))
-			if err != nil {
-				resultChan <- ServiceResult{Name: "auth", Error: err}
-				return
-			}
-
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", authHeader)
-
-			resp := g.proxyRequest(targetURL, req, authBody)
+			resp := g.writeAuthFields(r, username, authHeader, authFields)
 			resultChan <- ServiceResult{Name: "auth", Response: resp}
 		}()
 	}
 
 	// Actualizar en servicio de perfiles si hay campos
-	if len(profileFields) > 0 {
+	if len(profileFieldsSnake) > 0 {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-
-			// Convertir campos al formato snake_case que espera el servicio de profiles
-			profileFieldsSnake := make(map[string]interface{})
-			fieldMapping := map[string]string{
-				"personalUrl":       "personal_url",
-				"mailingAddress":    "mailing_address",
-				"contactInfoPublic": "contact_info_public",
-				"profileVisibility": "profile_visibility",
-				"githubUrl":         "github_url",
-				"linkedinUrl":       "linkedin_url",
-				"twitterUrl":        "twitter_url",
-				"facebookUrl":       "facebook_url",
-				"instagramUrl":      "instagram_url",
-				"websiteUrl":        "website_url",
-			}
-
-			for key, val := range profileFields {
-				if snakeKey, exists := fieldMapping[key]; exists {
-					profileFieldsSnake[snakeKey] = val
-				} else {
-					profileFieldsSnake[key] = val
-				}
-			}
-
-			profileBody, _ := json.Marshal(profileFieldsSnake)
-			targetURL := g.config.ProfileServiceURL + "/profiles/me"
-
-			// Crear request PUT para el servicio de profiles
-			req, err := http.NewRequest("PUT", targetURL, bytes.NewReader(profileBody))
-			if err != nil {
-				resultChan <- ServiceResult{Name: "profile", Error: err}
-				return
-			}
-
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", authHeader)
-
-			resp := g.proxyRequest(targetURL, req, profileBody)
+			resp := g.writeProfileFields(r, authHeader, profileFieldsSnake)
 			resultChan <- ServiceResult{Name: "profile", Response: resp}
 		}()
 	}
@@ -590,223 +435,54 @@ func (g *Gateway) handleUpdateUserUnified(w http.ResponseWriter, r *http.Request
 	}()
 
 	// Recolectar respuestas
-	results := make(map[string]*ServiceResponse)
 	errors := []string{}
+	failed := []string{}
+	succeeded := []string{}
 
 	for result := range resultChan {
-		if result.Error != nil {
-			errors = append(errors, fmt.Sprintf("%s: %v", result.Name, result.Error))
-			continue
-		}
-		results[result.Name] = result.Response
-
-		if result.Response.StatusCode != 200 {
+		switch {
+		case result.Response.Error != nil:
+			errors = append(errors, fmt.Sprintf("%s: %v", result.Name, result.Response.Error))
+			failed = append(failed, result.Name)
+		case result.Response.StatusCode != 200:
 			errors = append(errors, fmt.Sprintf("%s returned status %d", result.Name, result.Response.StatusCode))
+			failed = append(failed, result.Name)
+		default:
+			succeeded = append(succeeded, result.Name)
 		}
 	}
 
-	// Si hubo errores, reportarlos
+	// Si hubo errores, compensar lo que sí se aplicó y reportarlo
 	if len(errors) > 0 {
 		errorMsg := strings.Join(errors, "; ")
-		log.Printf("[Gateway] Errors updating user: %s", errorMsg)
-		http.Error(w, fmt.Sprintf(`{"error":"Partial update failed: %s"}`, errorMsg), http.StatusInternalServerError)
-		return
-	}
-
-	// Obtener datos actualizados
-	g.handleGetUserUnified(w, r)
-
-	log.Printf("[Gateway] Unified UPDATE user request completed successfully")
-}
-
-// ============================================
-// HANDLER - OBTENER PERFIL DEL USUARIO AUTENTICADO
-// ============================================
-
-func (g *Gateway) handleGetMyProfile(w http.ResponseWriter, r *http.Request) {
-	log.Println("[Gateway] Processing GET my profile request")
-
-	// Extraer token de autorización
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, `{"error":"Authorization header required"}`, http.StatusUnauthorized)
-		return
-	}
-
-	// Proxy al servicio de perfiles
-	targetURL := g.config.ProfileServiceURL + "/profiles/me"
-	resp := g.proxyRequest(targetURL, r, nil)
-
-	if resp.Error != nil {
-		log.Printf("[Gateway] Error proxying to profile service: %v", resp.Error)
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
-		return
-	}
-
-	// Copiar headers de respuesta
-	for key, values := range resp.Headers {
-		for _, value := range values {
-			w.Header().Add(key, value)
+		slog.ErrorContext(r.Context(), "Errors updating user", "username", username, "error", errorMsg)
+
+		outcome := map[string]interface{}{
+			"error":          "Partial update failed: " + errorMsg,
+			"failed":         failed,
+			"rolledBack":     []string{},
+			"rollbackFailed": []string{},
+			"notRestored":    []string{},
+			"consistent":     len(succeeded) == 0,
 		}
-	}
-
-	// Enviar respuesta
-	w.WriteHeader(resp.StatusCode)
-	w.Write(resp.Body)
-
-	log.Printf("[Gateway] Get my profile request completed - Status: %d", resp.StatusCode)
-}
-
-// ============================================
-// HANDLER - ACTUALIZAR PERFIL DEL USUARIO AUTENTICADO
-// ============================================
-
-func (g *Gateway) handleUpdateMyProfile(w http.ResponseWriter, r *http.Request) {
-	log.Println("[Gateway] Processing UPDATE my profile request")
-
-	// Extraer token de autorización
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, `{"error":"Authorization header required"}`, http.StatusUnauthorized)
-		return
-	}
-
-	// Leer body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
-		return
-	}
-
-	// Proxy al servicio de perfiles
-	targetURL := g.config.ProfileServiceURL + "/profiles/me"
-	resp := g.proxyRequest(targetURL, r, body)
-
-	if resp.Error != nil {
-		log.Printf("[Gateway] Error proxying to profile service: %v", resp.Error)
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
-		return
-	}
-
-	// Copiar headers de respuesta
-	for key, values := range resp.Headers {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
-	}
-
-	// Enviar respuesta
-	w.WriteHeader(resp.StatusCode)
-	w.Write(resp.Body)
-
-	log.Printf("[Gateway] Update my profile request completed - Status: %d", resp.StatusCode)
-}
-
-// ============================================
-// HANDLER - BUSCAR PERFILES PÚBLICOS
-// ============================================
-
-func (g *Gateway) handleSearchProfiles(w http.ResponseWriter, r *http.Request) {
-	log.Println("[Gateway] Processing search profiles request")
-
-	// Construir URL con query parameters
-	targetURL := g.config.ProfileServiceURL + "/profiles/search"
-	if r.URL.RawQuery != "" {
-		targetURL += "?" + r.URL.RawQuery
-	}
-
-	resp := g.proxyRequest(targetURL, r, nil)
-
-	if resp.Error != nil {
-		log.Printf("[Gateway] Error proxying to profile service: %v", resp.Error)
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
-		return
-	}
-
-	// Copiar headers de respuesta
-	for key, values := range resp.Headers {
-		for _, value := range values {
-			w.Header().Add(key, value)
+		if snapshot != nil && len(succeeded) > 0 {
+			rolledBack, rollbackFailed, notRestored := g.compensateUserUpdate(r, username, authHeader, snapshot, succeeded)
+			outcome["rolledBack"] = rolledBack
+			outcome["rollbackFailed"] = rollbackFailed
+			outcome["notRestored"] = notRestored
+			outcome["consistent"] = len(rollbackFailed) == 0 && len(notRestored) == 0
 		}
-	}
-
-	// Enviar respuesta
-	w.WriteHeader(resp.StatusCode)
-	w.Write(resp.Body)
-
-	log.Printf("[Gateway] Search profiles request completed - Status: %d", resp.StatusCode)
-}
-
-// ============================================
-// HANDLER - OBTENER PERFIL PÚBLICO POR USERNAME
-// ============================================
-
-func (g *Gateway) handleGetPublicProfile(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	username := vars["username"]
-
-	log.Printf("[Gateway] Processing GET public profile request for: %s", username)
-
-	// Proxy al servicio de perfiles
-	targetURL := g.config.ProfileServiceURL + "/profiles/" + username
-	resp := g.proxyRequest(targetURL, r, nil)
-
-	if resp.Error != nil {
-		log.Printf("[Gateway] Error proxying to profile service: %v", resp.Error)
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
-		return
-	}
-
-	// Copiar headers de respuesta
-	for key, values := range resp.Headers {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
-	}
-
-	// Enviar respuesta
-	w.WriteHeader(resp.StatusCode)
-	w.Write(resp.Body)
-
-	log.Printf("[Gateway] Get public profile request completed - Status: %d", resp.StatusCode)
-}
-
-// ============================================
-// HANDLER - OBTENER ESTADÍSTICAS DEL PERFIL
-// ============================================
-
-func (g *Gateway) handleGetProfileStats(w http.ResponseWriter, r *http.Request) {
-	log.Println("[Gateway] Processing GET profile stats request")
-
-	// Extraer token de autorización
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, `{"error":"Authorization header required"}`, http.StatusUnauthorized)
-		return
-	}
-
-	// Proxy al servicio de perfiles
-	targetURL := g.config.ProfileServiceURL + "/profiles/stats/me"
-	resp := g.proxyRequest(targetURL, r, nil)
 
-	if resp.Error != nil {
-		log.Printf("[Gateway] Error proxying to profile service: %v", resp.Error)
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(outcome)
 		return
 	}
 
-	// Copiar headers de respuesta
-	for key, values := range resp.Headers {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
-	}
-
-	// Enviar respuesta
-	w.WriteHeader(resp.StatusCode)
-	w.Write(resp.Body)
+	// Obtener datos actualizados
+	g.handleGetUserUnified(w, r)
 
-	log.Printf("[Gateway] Get profile stats request completed - Status: %d", resp.StatusCode)
+	slog.InfoContext(r.Context(), "Unified UPDATE user request completed", "username", username)
 }
 
 // ============================================
@@ -814,21 +490,46 @@ func (g *Gateway) handleGetProfileStats(w http.ResponseWriter, r *http.Request)
 // ============================================
 
 func (g *Gateway) handleHealth(w http.ResponseWriter, r *http.Request) {
+	upstreams := map[string]interface{}{}
+	for _, name := range []string{"auth", "profiles", "orchestrator"} {
+		upstreams[name] = g.upstreamStatus(name)
+	}
+
 	health := map[string]interface{}{
 		"status":    "UP",
 		"service":   "api-gateway",
 		"timestamp": time.Now().Format(time.RFC3339),
-		"upstreams": map[string]string{
-			"auth":         g.config.AuthServiceURL,
-			"profiles":     g.config.ProfileServiceURL,
-			"orchestrator": g.config.OrchestratorURL,
-		},
+		"upstreams": upstreams,
+	}
+
+	// Durante el apagado el check falla para que Consul deje de enviar tráfico
+	status := http.StatusOK
+	if g.draining.Load() {
+		health["status"] = "SHUTTING_DOWN"
+		status = http.StatusServiceUnavailable
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(health)
 }
 
+// upstreamStatus describe de dónde salen las URLs de un upstream y en qué
+// estado está su circuit breaker
+func (g *Gateway) upstreamStatus(name string) map[string]interface{} {
+	circuit := g.breakers[name].State().String()
+	if g.discovery != nil {
+		if instances := g.discovery.Instances(name); len(instances) > 0 {
+			urls := make([]string, 0, len(instances))
+			for _, instance := range instances {
+				urls = append(urls, instance.URL())
+			}
+			return map[string]interface{}{"source": "consul", "instances": urls, "circuit": circuit}
+		}
+	}
+	return map[string]interface{}{"source": "static", "instances": []string{g.staticUpstreamURL(name)}, "circuit": circuit}
+}
+
 // ============================================
 // HANDLER - DOCUMENTACIÓN
 // ============================================
@@ -910,6 +611,8 @@ func (g *Gateway) handleDocsRoot(w http.ResponseWriter, r *http.Request) {
                 <li><strong>PATCH</strong> /api/v1/users/{username}/profile - Actualizar perfil</li>
                 <li><strong>DELETE</strong> /api/v1/users/{username} - Eliminar cuenta</li>
                 <li><strong>GET</strong> /health - Health check</li>
+                <li><strong>GET</strong> /health/live - Liveness</li>
+                <li><strong>GET</strong> /health/ready - Readiness (consulta auth, profiles y orchestrator)</li>
             </ul>
         </div>
         
@@ -935,7 +638,7 @@ func (g *Gateway) handleOpenAPIYAML(w http.ResponseWriter, r *http.Request) {
 
 	// Verificar si existe
 	if _, err := os.Stat(yamlPath); err != nil {
-		log.Printf("[Gateway] openapi.yaml not found at: %s", yamlPath)
+		slog.ErrorContext(r.Context(), "openapi.yaml not found", "path", yamlPath)
 		http.Error(w, "openapi.yaml not found", http.StatusNotFound)
 		return
 	}
@@ -1031,29 +734,35 @@ func (g *Gateway) setupRoutes() *mux.Router {
 	router.HandleFunc("/docs/openapi.yaml", g.handleOpenAPIYAML).Methods("GET")
 	router.HandleFunc("/docs/openapi.json", g.handleOpenAPIJSON).Methods("GET")
 
+	// Límite de tamaño del body para todas las rutas
+	router.Use(g.limitRequestBody)
+
 	// Health check
 	router.HandleFunc("/health", g.handleHealth).Methods("GET")
+	router.HandleFunc("/health/live", g.handleLiveness).Methods("GET")
+	router.HandleFunc("/health/ready", g.handleReadiness).Methods("GET")
+
+	// Métricas Prometheus
+	router.Handle(g.metricsPath(), g.metrics.handler()).Methods("GET")
+
+	// Administración del outbox de eventos
+	if g.outbox != nil {
+		router.HandleFunc("/admin/outbox", g.requireAdmin(g.handleListOutbox)).Methods("GET")
+		router.HandleFunc("/admin/outbox/{seq:[0-9]+}/replay", g.requireAdmin(g.handleReplayOutbox)).Methods("POST")
+	}
 
 	// API v1 routes
 	api := router.PathPrefix("/api/v1").Subrouter()
 
-	// Autenticación
-	api.HandleFunc("/auth/login", g.handleLogin).Methods("POST")
-	api.HandleFunc("/auth/register", g.handleRegister).Methods("POST")
-
 	// Gestión de usuarios - Operaciones simples
-	api.HandleFunc("/users/{username}", g.handleDeleteUser).Methods("DELETE")
+	api.HandleFunc("/users/{username}", g.requireAuth(withRouteTimeout(defaultRouteTimeout, g.handleDeleteUser))).Methods("DELETE")
 
 	// Gestión de usuarios - Operaciones unificadas
-	api.HandleFunc("/users/{username}/profile", g.handleGetUserUnified).Methods("GET")
-	api.HandleFunc("/users/{username}/profile", g.handleUpdateUserUnified).Methods("PATCH", "PUT")
+	api.HandleFunc("/users/{username}/profile", g.requireAuth(g.handleGetUserUnified)).Methods("GET")
+	api.HandleFunc("/users/{username}/profile", g.requireAuth(g.idempotent(withRouteTimeout(defaultRouteTimeout, g.handleUpdateUserUnified)))).Methods("PATCH", "PUT")
 
-	// Perfiles - Endpoints específicos del servicio de profiles
-	api.HandleFunc("/profiles/me", g.handleGetMyProfile).Methods("GET")
-	api.HandleFunc("/profiles/me", g.handleUpdateMyProfile).Methods("PUT")
-	api.HandleFunc("/profiles/search", g.handleSearchProfiles).Methods("GET")
-	api.HandleFunc("/profiles/{username}", g.handleGetPublicProfile).Methods("GET")
-	api.HandleFunc("/profiles/stats/me", g.handleGetProfileStats).Methods("GET")
+	// Rutas proxy declaradas en el archivo de rutas (auth, profiles, ...)
+	g.registerRouteTable(router)
 
 	return router
 }
@@ -1063,6 +772,19 @@ func (g *Gateway) setupRoutes() *mux.Router {
 // ============================================
 
 func main() {
+	// Logger estructurado; se configura antes que el resto para que los avisos
+	// de configuración ya salgan en el formato final
+	logLevel, logLevelErr := parseLogLevel(getEnv("LOG_LEVEL", "info"))
+	logging := LoggingConfig{
+		Level:   logLevel,
+		Format:  getEnv("LOG_FORMAT", "json"),
+		Service: getEnv("SERVICE_NAME", "api-gateway"),
+	}
+	slog.SetDefault(NewLogger(os.Stdout, logging))
+	if logLevelErr != nil {
+		slog.Warn("Invalid LOG_LEVEL, using info", "error", logLevelErr)
+	}
+
 	// Cargar configuración
 	config := &Config{
 		Port:              getEnv("GATEWAY_PORT", "8000"),
@@ -1070,59 +792,276 @@ func main() {
 		ProfileServiceURL: getEnv("PROFILE_SERVICE_URL", "http://profiles:3600"),
 		OrchestratorURL:   getEnv("ORCHESTRATOR_URL", "http://orchestrator:8080"),
 		JWTSecret:         getEnv("JWT_SECRET", "mi_secreto_super_seguro"),
+		JWTIssuer:         getEnv("JWT_ISSUER", ""),
+		JWTAudience:       getEnv("JWT_AUDIENCE", ""),
+		JWTClockSkew:      getEnvDuration("JWT_CLOCK_SKEW", 30*time.Second),
+		RoutesFile:        getEnv("ROUTES_FILE", "config/routes.yaml"),
+		CompositionsFile:  getEnv("COMPOSITIONS_FILE", "config/compositions.yaml"),
+		CORSFile:          getEnv("CORS_FILE", "config/cors.yaml"),
+		ConsulServices: map[string]string{
+			"auth":         getEnv("CONSUL_AUTH_SERVICE", "auth"),
+			"profiles":     getEnv("CONSUL_PROFILES_SERVICE", "profiles"),
+			"orchestrator": getEnv("CONSUL_ORCHESTRATOR_SERVICE", "orchestrator"),
+		},
+		ConsulWait:     getEnvDuration("CONSUL_WAIT", 5*time.Minute),
+		ConsulRegister: getEnv("CONSUL_REGISTER", "true") == "true",
+		ServiceName:    getEnv("SERVICE_NAME", "api-gateway"),
+		ServiceAddress: getEnv("SERVICE_ADDRESS", "api-gateway"),
+		MetricsPath:    getEnv("METRICS_PATH", "/metrics"),
+
+		RabbitMQURL:           getEnv("RABBITMQ_URL", rabbitURLFromEnv()),
+		EventsExchange:        getEnv("AUTH_EVENTS_EXCHANGE", "auth.events"),
+		UserDeletedRoutingKey: getEnv("USER_DELETED_ROUTING_KEY", "user.deleted"),
+		LoginLockedRoutingKey: getEnv("LOGIN_LOCKED_ROUTING_KEY", "security.login_locked"),
+		OutboxPath:            getEnv("OUTBOX_PATH", "data/outbox.db"),
+		OutboxPollInterval:    getEnvDuration("OUTBOX_POLL_INTERVAL", 2*time.Second),
+		OutboxMaxAttempts:     getEnvInt("OUTBOX_MAX_ATTEMPTS", 10),
+
+		UnifiedUpdateSaga: getEnv("UNIFIED_UPDATE_SAGA", "true") == "true",
+		Breaker: BreakerSettings{
+			Window:         getEnvInt("BREAKER_WINDOW", 20),
+			MinRequests:    getEnvInt("BREAKER_MIN_REQUESTS", 10),
+			FailureRate:    getEnvFloat("BREAKER_FAILURE_RATE", 0.5),
+			OpenTimeout:    getEnvDuration("BREAKER_OPEN_TIMEOUT", 30*time.Second),
+			HalfOpenProbes: getEnvInt("BREAKER_HALF_OPEN_PROBES", 1),
+		},
+		Retry: RetryPolicy{
+			Attempts:       getEnvInt("RETRY_ATTEMPTS", 2),
+			InitialBackoff: getEnvDuration("RETRY_INITIAL_BACKOFF", 100*time.Millisecond),
+			MaxBackoff:     getEnvDuration("RETRY_MAX_BACKOFF", time.Second),
+		},
+		RetryBudgetRatio: getEnvFloat("RETRY_BUDGET_RATIO", 0.2),
+		RetryBudgetMax:   getEnvInt("RETRY_BUDGET_MAX", 10),
+		IdempotencyTTL:   getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		RateLimitEnabled: getEnv("RATE_LIMIT_ENABLED", "true") == "true",
+		RateLimitsFile:   getEnv("RATE_LIMITS_FILE", "config/ratelimits.yaml"),
+		RateLimitRedis:   getEnv("RATE_LIMIT_REDIS_ADDR", ""),
+		LoginLockout: LockoutPolicy{
+			MaxFailures: getEnvInt("LOGIN_LOCKOUT_MAX_FAILURES", 5),
+			Window:      getEnvDuration("LOGIN_LOCKOUT_WINDOW", 15*time.Minute),
+			Cooldown:    getEnvDuration("LOGIN_LOCKOUT_COOLDOWN", 15*time.Minute),
+		},
+		Logging: logging,
+		// docker stop espera 10s antes de matar el contenedor
+		Shutdown: ShutdownSettings{
+			Delay:   getEnvDuration("SHUTDOWN_DELAY", 0),
+			Timeout: getEnvDuration("SHUTDOWN_TIMEOUT", 8*time.Second),
+		},
+		MaxRequestBytes:  int64(getEnvInt("MAX_REQUEST_BODY_BYTES", 10<<20)),
+		MaxResponseBytes: int64(getEnvInt("MAX_RESPONSE_BODY_BYTES", 50<<20)),
+		// Sin auth no hay login ni validación de usuarios: es la única
+		// dependencia crítica
+		Health: HealthSettings{
+			Timeout:  getEnvDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+			CacheTTL: getEnvDuration("HEALTH_CACHE_TTL", 5*time.Second),
+			Dependencies: []DependencyCheck{
+				{Upstream: "auth", Path: getEnv("AUTH_HEALTH_PATH", "/health"), Critical: true},
+				{Upstream: "profiles", Path: getEnv("PROFILES_HEALTH_PATH", "/health")},
+				{Upstream: "orchestrator", Path: getEnv("ORCHESTRATOR_HEALTH_PATH", "/actuator/health/ready")},
+			},
+		},
+		Tracing: TracingConfig{
+			Endpoint:    getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+			ServiceName: getEnv("OTEL_SERVICE_NAME", "api-gateway"),
+			SampleRatio: getEnvFloat("OTEL_TRACES_SAMPLE_RATIO", 1),
+		},
+	}
+	hostname, _ := os.Hostname()
+	config.ServiceID = getEnv("SERVICE_ID", config.ServiceName+"-"+hostname)
+	if consulHost := getEnv("CONSUL_HOST", ""); consulHost != "" {
+		config.ConsulAddr = "http://" + consulHost + ":" + getEnv("CONSUL_PORT", "8500")
 	}
 
 	// Crear gateway
 	gateway := NewGateway(config)
 
+	// Trazas OpenTelemetry; el provider global lo usa el dispatcher del outbox
+	tracerProvider, err := NewTracerProvider(context.Background(), config.Tracing)
+	if err != nil {
+		log.Fatal("Failed to configure tracing: ", err)
+	}
+	otel.SetTracerProvider(tracerProvider)
+	gateway.tracerProvider = tracerProvider
+
+	// Cargar tabla de rutas
+	routeTable, err := LoadRouteTable(config.RoutesFile)
+	if err != nil {
+		log.Fatal("Failed to load route table: ", err)
+	}
+	gateway.routeTable = routeTable
+
+	// Proxies de confianza (IPs o CIDRs separados por coma) cuyas cabeceras
+	// X-Forwarded-* se conservan
+	trustedProxies, err := ParseTrustedProxies(getEnv("TRUSTED_PROXIES", ""))
+	if err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
+	config.TrustedProxies = trustedProxies
+
+	// Patrón que deben cumplir los parámetros de ruta que van a los upstreams
+	config.PathParamPattern, err = compilePathParamPattern(getEnv("PATH_PARAM_PATTERN", defaultPathParamPattern))
+	if err != nil {
+		log.Fatal("Invalid PATH_PARAM_PATTERN: ", err)
+	}
+
+	// Política CORS; CORS_ALLOWED_ORIGINS (separados por coma) reemplaza la lista del archivo
+	corsPolicy, err := LoadCORSPolicy(config.CORSFile)
+	if err != nil {
+		log.Fatal("Failed to load CORS policy: ", err)
+	}
+	if origins := getEnv("CORS_ALLOWED_ORIGINS", ""); origins != "" {
+		corsPolicy.AllowedOrigins = nil
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				corsPolicy.AllowedOrigins = append(corsPolicy.AllowedOrigins, origin)
+			}
+		}
+		if err := corsPolicy.validate(); err != nil {
+			log.Fatal("Invalid CORS_ALLOWED_ORIGINS: ", err)
+		}
+	}
+	gateway.cors = corsPolicy
+
+	// Rate limiting por grupo de rutas
+	if config.RateLimitEnabled {
+		rateLimitGroups, err := LoadRateLimitGroups(config.RateLimitsFile)
+		if err != nil {
+			log.Fatal("Failed to load rate limits: ", err)
+		}
+		var rateLimitStore RateLimitStore = NewMemoryRateLimitStore()
+		if config.RateLimitRedis != "" {
+			rateLimitStore = NewRedisRateLimitStore(redis.NewClient(&redis.Options{
+				Addr:     config.RateLimitRedis,
+				Password: getEnv("RATE_LIMIT_REDIS_PASSWORD", ""),
+				DB:       getEnvInt("RATE_LIMIT_REDIS_DB", 0),
+			}))
+		}
+		gateway.rateLimiter = &RateLimiter{groups: rateLimitGroups, store: rateLimitStore}
+	}
+
+	// Bloqueo de login tras varios fallos (0 fallos = desactivado)
+	if config.LoginLockout.MaxFailures > 0 {
+		gateway.loginAttempts = NewMemoryLoginAttemptStore()
+	}
+
+	// Respuestas guardadas por Idempotency-Key
+	switch store := getEnv("IDEMPOTENCY_STORE", "memory"); store {
+	case "memory":
+		gateway.idempotency = NewMemoryIdempotencyStore()
+	default:
+		log.Fatalf("Unknown IDEMPOTENCY_STORE %q", store)
+	}
+
+	// Cargar composiciones de respuestas (vista unificada de usuario)
+	compositions, err := LoadCompositions(config.CompositionsFile)
+	if err != nil {
+		log.Fatal("Failed to load compositions: ", err)
+	}
+	if _, ok := compositions[unifiedUserComposition]; !ok {
+		log.Fatalf("Composition %q missing from %s", unifiedUserComposition, config.CompositionsFile)
+	}
+	gateway.compositions = compositions
+
+	// Los loops de fondo se detienen en el apagado
+	background, stopBackground := context.WithCancel(context.Background())
+
+	// Descubrimiento de upstreams por Consul
+	if config.ConsulAddr != "" {
+		gateway.discovery = NewServiceDiscovery(NewConsulClient(config.ConsulAddr), config.ConsulServices, config.ConsulWait)
+		gateway.discovery.Start(background)
+	}
+
+	// Outbox de eventos y publicación en RabbitMQ
+	outboxStore, err := OpenOutboxStore(config.OutboxPath)
+	if err != nil {
+		log.Fatal("Failed to open outbox: ", err)
+	}
+	publisher := NewRabbitPublisher(config.RabbitMQURL, config.EventsExchange, 5*time.Second)
+	gateway.outbox = NewOutboxDispatcher(outboxStore, publisher, config.OutboxPollInterval, config.OutboxMaxAttempts)
+	gateway.outbox.Start(background)
+
 	// Configurar router
 	router := gateway.setupRoutes()
 
 	// Aplicar middlewares
-	handler := gateway.loggingMiddleware(gateway.corsMiddleware(router))
-
-	// Información de inicio
-	log.Println("===========================================")
-	log.Printf("API Gateway started on port %s", config.Port)
-	log.Println("===========================================")
-	log.Println("📚 Documentación:")
-	log.Println("  GET  /docs                  - Portal de documentación")
-	log.Println("  GET  /docs/swagger          - Swagger UI (pruebas interactivas)")
-	log.Println("  GET  /docs/openapi.yaml    - OpenAPI spec (YAML)")
-	log.Println("  GET  /docs/openapi.json    - OpenAPI spec (JSON)")
-	log.Println("===========================================")
-	log.Println("Upstream services:")
-	log.Printf("  - Auth:        %s", config.AuthServiceURL)
-	log.Printf("  - Profiles:    %s ✅ INTEGRATED", config.ProfileServiceURL)
-	log.Printf("  - Orchestrator: %s", config.OrchestratorURL)
-	log.Println("===========================================")
-	log.Println("Available endpoints:")
-	log.Println("🔐 Authentication:")
-	log.Println("  POST   /api/v1/auth/login")
-	log.Println("  POST   /api/v1/auth/register")
-	log.Println("👤 User Management:")
-	log.Println("  DELETE /api/v1/users/{username}")
-	log.Println("  GET    /api/v1/users/{username}/profile    (unified)")
-	log.Println("  PATCH  /api/v1/users/{username}/profile    (unified)")
-	log.Println("📋 Profiles Service:")
-	log.Println("  GET    /api/v1/profiles/me")
-	log.Println("  PUT    /api/v1/profiles/me")
-	log.Println("  GET    /api/v1/profiles/search")
-	log.Println("  GET    /api/v1/profiles/{username}")
-	log.Println("  GET    /api/v1/profiles/stats/me")
-	log.Println("🏥 Health:")
-	log.Println("  GET    /health")
-	log.Println("===========================================")
-	log.Println("🔗 Abre en tu navegador:")
-	log.Printf("   http://localhost:%s/docs/swagger", config.Port)
-	log.Println("===========================================")
+	handler := gateway.tracingMiddleware(router, gateway.loggingMiddleware(router, gateway.metricsMiddleware(router, gateway.corsMiddleware(router, gateway.rateLimitMiddleware(router, router)))))
+
+	// Información de inicio: una sola línea estructurada, como el resto de logs
+	routes := make([]string, 0, len(routeTable.Routes))
+	for _, route := range routeTable.Routes {
+		routes = append(routes, route.Method+" "+route.Path+" -> "+route.Upstream+route.UpstreamPath)
+	}
+	startup := []interface{}{
+		"port", config.Port,
+		"docs", "http://localhost:" + config.Port + "/docs/swagger",
+		slog.Group("upstreams",
+			"auth", config.AuthServiceURL,
+			"profiles", config.ProfileServiceURL,
+			"orchestrator", config.OrchestratorURL,
+			"consul", config.ConsulAddr,
+		),
+		slog.Group("events",
+			"exchange", config.EventsExchange,
+			"user_deleted", config.UserDeletedRoutingKey,
+			"login_locked", config.LoginLockedRoutingKey,
+		),
+		"routes_file", config.RoutesFile,
+		"routes", routes,
+		"cors_origins", corsPolicy.AllowedOrigins,
+		"metrics_path", config.MetricsPath,
+		"traces_endpoint", config.Tracing.Endpoint,
+	}
+	if gateway.rateLimiter != nil {
+		backend := "memory"
+		if config.RateLimitRedis != "" {
+			backend = "redis " + config.RateLimitRedis
+		}
+		limits := make([]string, 0, len(gateway.rateLimiter.groups))
+		for _, group := range gateway.rateLimiter.groups {
+			limits = append(limits, fmt.Sprintf("%s %d/%s burst %d", group.Name, group.Limit.Requests, group.Limit.Period, group.Limit.Burst))
+		}
+		startup = append(startup, slog.Group("rate_limits", "file", config.RateLimitsFile, "backend", backend, "groups", limits))
+	}
+	slog.Info("API Gateway started", startup...)
+
+	// Registro en Consul (Prometheus descubre los targets por ahí)
+	deregister := func() {}
+	if config.ConsulAddr != "" && config.ConsulRegister {
+		deregister = registerGateway(NewConsulClient(config.ConsulAddr), config)
+	}
 
 	// Iniciar servidor
-	addr := ":" + config.Port
-	log.Printf("Listening on %s", addr)
-	if err := http.ListenAndServe(addr, handler); err != nil {
-		log.Fatal("Server failed to start:", err)
+	server := &http.Server{
+		Addr:              ":" + config.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
 	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	slog.Info("Listening", "addr", server.Addr)
+
+	select {
+	case err := <-serverErr:
+		log.Fatal("Server failed to start: ", err)
+	case sig := <-signals:
+		slog.Info("Received signal", "signal", sig.String())
+	}
+
+	// Apagado ordenado: peticiones y eventos en curso, luego baja en Consul
+	gateway.shutdown(server, config.Shutdown, stopBackground, deregister)
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	if err := tracerProvider.Shutdown(flushCtx); err != nil {
+		slog.Warn("Error flushing traces", "error", err)
+	}
+	cancel()
+	publisher.Close()
+	outboxStore.Close()
+	slog.Info("Shutdown complete")
 }
 
 func getEnv(key, defaultValue string) string {
@@ -1131,3 +1070,53 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("Invalid duration, using default", "key", key, "value", value, "default", defaultValue.String())
+		return defaultValue
+	}
+	return duration
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Invalid integer, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		slog.Warn("Invalid number, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
+// rabbitURLFromEnv arma la URL AMQP con las mismas variables que usa rabbitmq/.env
+func rabbitURLFromEnv() string {
+	vhost := getEnv("RABBITMQ_VHOST", "/")
+	return (&url.URL{
+		Scheme: "amqp",
+		User:   url.UserPassword(getEnv("RABBITMQ_USER", "admin"), getEnv("RABBITMQ_PASSWORD", "securepass")),
+		Host:   getEnv("RABBITMQ_HOST", "rabbitmq") + ":" + getEnv("RABBITMQ_PORT", "5672"),
+		Path:   "/" + strings.TrimPrefix(vhost, "/"),
+	}).String()
+}
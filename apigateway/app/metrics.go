@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ============================================
+// MÉTRICAS - PROMETHEUS
+// ============================================
+
+// gatewayMetrics agrupa las métricas RED del gateway. Los nombres y labels de
+// las peticiones entrantes siguen a Micrometer (http_server_requests_seconds
+// con uri/method/status) para que las reglas de services-rules.yml sirvan
+// igual para el gateway que para los servicios Spring.
+type gatewayMetrics struct {
+	registry         *prometheus.Registry
+	requestDuration  *prometheus.HistogramVec
+	upstreamDuration *prometheus.HistogramVec
+	upstreamErrors   *prometheus.CounterVec
+	upstreamCanceled *prometheus.CounterVec
+	breakerState     *prometheus.GaugeVec
+	breakerChanges   *prometheus.CounterVec
+	upstreamRetries  *prometheus.CounterVec
+	rateLimited      *prometheus.CounterVec
+}
+
+func newGatewayMetrics() *gatewayMetrics {
+	m := &gatewayMetrics{
+		registry: prometheus.NewRegistry(),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_server_requests_seconds",
+			Help:    "Duration of HTTP requests handled by the gateway, by route template.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"uri", "method", "status"}),
+		upstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gateway_upstream_request_duration_seconds",
+			Help:    "Duration of calls from the gateway to upstream services.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"upstream", "method", "status"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_upstream_errors_total",
+			Help: "Upstream calls that failed without an HTTP response.",
+		}, []string{"upstream", "reason"}),
+		upstreamCanceled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_upstream_cancelled_total",
+			Help: "Upstream calls cancelled by the gateway, by reason (client_disconnected or not_needed).",
+		}, []string{"upstream", "reason"}),
+		breakerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_circuit_breaker_state",
+			Help: "Circuit breaker state per upstream: 0 closed, 1 half-open, 2 open.",
+		}, []string{"upstream"}),
+		breakerChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_circuit_breaker_transitions_total",
+			Help: "Circuit breaker state changes per upstream.",
+		}, []string{"upstream", "from", "to"}),
+		upstreamRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_upstream_retries_total",
+			Help: "Upstream retries, by outcome (retried or budget_exhausted).",
+		}, []string{"upstream", "outcome"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_rate_limited_requests_total",
+			Help: "Requests rejected with 429 by the rate limiter, by route group.",
+		}, []string{"group"}),
+	}
+
+	m.registry.MustRegister(
+		m.requestDuration,
+		m.upstreamDuration,
+		m.upstreamErrors,
+		m.upstreamCanceled,
+		m.breakerState,
+		m.breakerChanges,
+		m.upstreamRetries,
+		m.rateLimited,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// handler expone las métricas en formato Prometheus
+func (m *gatewayMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeUpstream registra una llamada a un upstream. status es 0 si no hubo
+// respuesta HTTP, en cuyo caso cuenta como error. Las llamadas canceladas no
+// son fallas del upstream: se cuentan aparte con observeCanceled.
+func (m *gatewayMetrics) observeUpstream(upstream, method string, status int, duration time.Duration, err error) {
+	statusLabel := strconv.Itoa(status)
+	switch {
+	case upstreamCanceled(err):
+		statusLabel = "cancelled"
+	case err != nil:
+		statusLabel = "error"
+		m.upstreamErrors.WithLabelValues(upstream, upstreamErrorReason(err)).Inc()
+	}
+	m.upstreamDuration.WithLabelValues(upstream, method, statusLabel).Observe(duration.Seconds())
+}
+
+// observeRejected cuenta una llamada que el circuit breaker no dejó salir
+func (m *gatewayMetrics) observeRejected(upstream string) {
+	m.upstreamErrors.WithLabelValues(upstream, "circuit_open").Inc()
+}
+
+// observeCanceled cuenta una llamada cortada porque el cliente se fue o porque
+// su resultado ya no hacía falta
+func (m *gatewayMetrics) observeCanceled(upstream, reason string) {
+	m.upstreamCanceled.WithLabelValues(upstream, reason).Inc()
+}
+
+// observeBreaker refleja un cambio de estado del circuit breaker
+func (m *gatewayMetrics) observeBreaker(upstream string, from, to breakerState) {
+	m.breakerState.WithLabelValues(upstream).Set(float64(to))
+	m.breakerChanges.WithLabelValues(upstream, from.String(), to.String()).Inc()
+}
+
+// observeRetry cuenta un reintento o uno que el presupuesto no permitió
+func (m *gatewayMetrics) observeRetry(upstream, outcome string) {
+	m.upstreamRetries.WithLabelValues(upstream, outcome).Inc()
+}
+
+func upstreamErrorReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, new(*net.OpError)):
+		return "connection"
+	}
+	return "other"
+}
+
+// metricsPath es la ruta de /metrics anunciada en Consul como metrics_path
+func (g *Gateway) metricsPath() string {
+	if g.config.MetricsPath == "" {
+		return "/metrics"
+	}
+	return g.config.MetricsPath
+}
+
+// ============================================
+// MIDDLEWARE - MÉTRICAS
+// ============================================
+
+// metricsMiddleware mide cada petición etiquetada con la plantilla de la ruta
+// de mux (p. ej. /api/v1/users/{username}) y no con el path real, para no
+// crear una serie por usuario.
+func (g *Gateway) metricsMiddleware(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		next.ServeHTTP(rw, r)
+
+		g.metrics.requestDuration.
+			WithLabelValues(routeTemplate(router, r), r.Method, strconv.Itoa(rw.statusCode)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// routeContextKey guarda la plantilla de la ruta que atiende la petición.
+// tracingMiddleware, el más externo, la resuelve una vez para todos los demás.
+const routeContextKey contextKey = "route"
+
+// routeTemplate devuelve la plantilla de la ruta que atiende r. Solo consulta
+// el router si la petición no pasó por tracingMiddleware.
+func routeTemplate(router *mux.Router, r *http.Request) string {
+	if template, ok := r.Context().Value(routeContextKey).(string); ok {
+		return template
+	}
+	template, _ := matchRoute(router, r)
+	return template
+}
+
+// matchRoute devuelve la plantilla de la ruta que atiende r y sus variables.
+// Los middlewares van antes del router, así que mux.Vars todavía está vacío.
+func matchRoute(router *mux.Router, r *http.Request) (string, map[string]string) {
+	if r.Method == http.MethodOptions {
+		return "PREFLIGHT", nil
+	}
+	var match mux.RouteMatch
+	if !router.Match(r, &match) || match.Route == nil {
+		if match.MatchErr == mux.ErrMethodMismatch {
+			return "METHOD_NOT_ALLOWED", nil
+		}
+		return "NOT_FOUND", nil
+	}
+	template, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return "UNKNOWN", match.Vars
+	}
+	return template, match.Vars
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddlewareUsesRouteTemplate(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer upstream.Close()
+
+	g := NewGateway(&Config{ProfileServiceURL: upstream.URL})
+	g.routeTable = &RouteTable{Routes: []RouteConfig{{
+		Name: "public-profile", Method: "GET", Path: "/api/v1/profiles/{username}",
+		Upstream: "profiles", UpstreamPath: "/profiles/{username}", Timeout: defaultRouteTimeout,
+	}}}
+	router := g.setupRoutes()
+	handler := g.metricsMiddleware(router, router)
+
+	for _, username := range []string{"alice", "bob"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/profiles/"+username, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nope", nil))
+
+	if got := testutil.CollectAndCount(g.metrics.requestDuration); got != 2 {
+		t.Fatalf("request series = %d, want 2 (template + NOT_FOUND)", got)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`http_server_requests_seconds_count{method="GET",status="502",uri="/api/v1/profiles/{username}"} 2`,
+		`http_server_requests_seconds_count{method="GET",status="404",uri="NOT_FOUND"} 1`,
+		`gateway_upstream_request_duration_seconds_count{method="GET",status="502",upstream="profiles"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
+
+func TestMiddlewaresMatchRouteOnce(t *testing.T) {
+	g := NewGateway(&Config{})
+	g.cors = &CORSPolicy{AllowedOrigins: []string{"https://app.example"}}
+	g.rateLimiter = &RateLimiter{
+		groups: []*RateLimitGroup{{Name: "items", Paths: []string{"/items/{id}"}, Limit: RateLimit{Requests: 10, Period: time.Minute, Burst: 10}}},
+		store:  NewMemoryRateLimitStore(),
+	}
+	matches := 0
+	router := mux.NewRouter()
+	router.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}).MatcherFunc(func(*http.Request, *mux.RouteMatch) bool {
+		matches++
+		return true
+	})
+	handler := g.tracingMiddleware(router, g.loggingMiddleware(router, g.metricsMiddleware(router, g.corsMiddleware(router, g.rateLimitMiddleware(router, router)))))
+
+	req := httptest.NewRequest("GET", "/items/7", nil)
+	req.Header.Set("Origin", "https://app.example")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// Una vez en tracingMiddleware y otra en el router al despachar
+	if rec.Code != http.StatusNoContent || rec.Header().Get("RateLimit-Limit") != "10" || matches != 2 {
+		t.Fatalf("status = %d, RateLimit-Limit = %q, router matches = %d, want 204, 10 and 2",
+			rec.Code, rec.Header().Get("RateLimit-Limit"), matches)
+	}
+}
+
+func TestUpstreamErrorsAreCounted(t *testing.T) {
+	g := NewGateway(&Config{})
+	req := httptest.NewRequest("GET", "/", nil)
+
+	resp := g.proxyRequest("auth", "http://127.0.0.1:1/accounts/bob", req, nil)
+	if resp.Error == nil {
+		t.Fatal("expected connection error")
+	}
+	if got := testutil.ToFloat64(g.metrics.upstreamErrors.WithLabelValues("auth", "connection")); got != 1 {
+		t.Fatalf("upstream errors = %v, want 1", got)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ============================================
+// OUTBOX - ALMACÉN DE EVENTOS PENDIENTES
+// ============================================
+
+const (
+	outboxStatusPending = "pending"
+	outboxStatusStuck   = "stuck"
+)
+
+var (
+	outboxBucket       = []byte("events")
+	errOutboxNotFound  = errors.New("outbox event not found")
+	outboxMaxBackoff   = 5 * time.Minute
+	outboxInitialDelay = time.Second
+)
+
+// OutboxRecord es un evento guardado a la espera de ser publicado
+type OutboxRecord struct {
+	Seq           uint64       `json:"seq"`
+	Event         *DomainEvent `json:"event"`
+	RoutingKey    string       `json:"routingKey"`
+	Status        string       `json:"status"`
+	Attempts      int          `json:"attempts"`
+	LastError     string       `json:"lastError,omitempty"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	NextAttemptAt time.Time    `json:"nextAttemptAt"`
+}
+
+// OutboxStore guarda los eventos en un archivo BoltDB embebido, así un evento
+// aceptado sobrevive a un reinicio o caída del proceso
+type OutboxStore struct {
+	db *bolt.DB
+}
+
+func OpenOutboxStore(path string) (*OutboxStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating outbox directory: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening outbox %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(outboxBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &OutboxStore{db: db}, nil
+}
+
+func (s *OutboxStore) Close() error {
+	return s.db.Close()
+}
+
+// Add guarda un evento nuevo. Las claves son secuenciales para que el
+// dispatcher respete el orden de llegada.
+func (s *OutboxStore) Add(event *DomainEvent) (*OutboxRecord, error) {
+	now := time.Now()
+	record := &OutboxRecord{
+		Event:         event,
+		RoutingKey:    event.RoutingKey,
+		Status:        outboxStatusPending,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		record.Seq = seq
+		return putRecord(bucket, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// List devuelve los eventos guardados; status vacío devuelve todos
+func (s *OutboxStore) List(status string) ([]*OutboxRecord, error) {
+	var records []*OutboxRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).ForEach(func(_, value []byte) error {
+			record, err := decodeRecord(value)
+			if err != nil {
+				return err
+			}
+			if status == "" || record.Status == status {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
+
+// Due devuelve los eventos pendientes cuyo próximo intento ya venció
+func (s *OutboxStore) Due(now time.Time, limit int) ([]*OutboxRecord, error) {
+	var records []*OutboxRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(outboxBucket).Cursor()
+		for key, value := cursor.First(); key != nil && len(records) < limit; key, value = cursor.Next() {
+			record, err := decodeRecord(value)
+			if err != nil {
+				return err
+			}
+			if record.Status == outboxStatusPending && !record.NextAttemptAt.After(now) {
+				records = append(records, record)
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// Delete borra un evento ya publicado
+func (s *OutboxStore) Delete(seq uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).Delete(seqKey(seq))
+	})
+}
+
+// Update aplica fn sobre el registro y lo guarda
+func (s *OutboxStore) Update(seq uint64, fn func(*OutboxRecord)) (*OutboxRecord, error) {
+	var record *OutboxRecord
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		value := bucket.Get(seqKey(seq))
+		if value == nil {
+			return errOutboxNotFound
+		}
+		var err error
+		if record, err = decodeRecord(value); err != nil {
+			return err
+		}
+		fn(record)
+		return putRecord(bucket, record)
+	})
+	return record, err
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+func putRecord(bucket *bolt.Bucket, record *OutboxRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(seqKey(record.Seq), value)
+}
+
+func decodeRecord(value []byte) (*OutboxRecord, error) {
+	var record OutboxRecord
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil, fmt.Errorf("decoding outbox record: %w", err)
+	}
+	if record.Event != nil {
+		record.Event.RoutingKey = record.RoutingKey
+	}
+	return &record, nil
+}
+
+// ============================================
+// OUTBOX - DISPATCHER
+// ============================================
+
+// OutboxDispatcher entrega los eventos pendientes con backoff exponencial. Tras
+// maxAttempts fallos el evento queda "stuck" hasta que se reenvíe a mano.
+type OutboxDispatcher struct {
+	store        *OutboxStore
+	publisher    EventPublisher
+	pollInterval time.Duration
+	maxAttempts  int
+	wake         chan struct{}
+	tracer       trace.Tracer
+	running      sync.WaitGroup
+}
+
+func NewOutboxDispatcher(store *OutboxStore, publisher EventPublisher, pollInterval time.Duration, maxAttempts int) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		store:        store,
+		publisher:    publisher,
+		pollInterval: pollInterval,
+		maxAttempts:  maxAttempts,
+		wake:         make(chan struct{}, 1),
+		tracer:       otel.Tracer(tracerName),
+	}
+}
+
+// Enqueue guarda el evento en el outbox y despierta al dispatcher
+func (d *OutboxDispatcher) Enqueue(event *DomainEvent) error {
+	if _, err := d.store.Add(event); err != nil {
+		return err
+	}
+	d.notify()
+	return nil
+}
+
+func (d *OutboxDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start lanza el loop que procesa el outbox hasta que se cancele ctx.
+// Cancelar ctx no corta una publicación en curso: el loop termina después de
+// ella. El loop se anota antes de lanzarlo para que Drain siempre lo espere.
+func (d *OutboxDispatcher) Start(ctx context.Context) {
+	d.running.Add(1)
+	go func() {
+		defer d.running.Done()
+		d.run(ctx)
+	}()
+}
+
+func (d *OutboxDispatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatchDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *OutboxDispatcher) dispatchDue(ctx context.Context) {
+	records, err := d.store.Due(time.Now(), 100)
+	if err != nil {
+		slog.Error("Error reading outbox", "error", err)
+		return
+	}
+
+	for _, record := range records {
+		if ctx.Err() != nil {
+			return
+		}
+		d.dispatch(context.WithoutCancel(ctx), record)
+	}
+}
+
+// Drain espera a que termine el loop de Start y publica los eventos
+// pendientes hasta que no quede ninguno listo o venza ctx. Devuelve cuántos
+// quedan en el outbox; no se pierden, se publican en el próximo arranque.
+func (d *OutboxDispatcher) Drain(ctx context.Context) (int, error) {
+	stopped := make(chan struct{})
+	go func() {
+		d.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return d.pending(), ctx.Err()
+	}
+
+	for {
+		records, err := d.store.Due(time.Now(), 100)
+		if err != nil {
+			return d.pending(), err
+		}
+		if len(records) == 0 {
+			// Si venció el plazo a mitad de una publicación, el evento quedó
+			// reprogramado y no aparece en Due
+			return d.pending(), ctx.Err()
+		}
+		for _, record := range records {
+			if ctx.Err() != nil {
+				return d.pending(), ctx.Err()
+			}
+			d.dispatch(ctx, record)
+		}
+	}
+}
+
+// pending cuenta los eventos que siguen pendientes de publicar
+func (d *OutboxDispatcher) pending() int {
+	records, err := d.store.List(outboxStatusPending)
+	if err != nil {
+		return -1
+	}
+	return len(records)
+}
+
+// dispatch publica un evento dentro de un span de productor que continúa la
+// traza de la petición que lo generó
+func (d *OutboxDispatcher) dispatch(ctx context.Context, record *OutboxRecord) {
+	ctx, span := d.tracer.Start(eventTraceContext(ctx, record.Event), record.Event.Type+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitmq,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingMessageID(record.Event.ID),
+			semconv.MessagingRabbitmqDestinationRoutingKey(record.Event.RoutingKey),
+		))
+	defer span.End()
+
+	err := d.publisher.Publish(ctx, record.Event)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	if err == nil {
+		if err := d.store.Delete(record.Seq); err != nil {
+			slog.ErrorContext(ctx, "Error removing published event from outbox", "event_id", record.Event.ID, "error", err)
+			return
+		}
+		slog.InfoContext(ctx, "Event published", "event", record.Event.Type, "event_id", record.Event.ID, "attempt", record.Attempts+1)
+		return
+	}
+
+	updated, updateErr := d.store.Update(record.Seq, func(r *OutboxRecord) {
+		r.Attempts++
+		r.LastError = err.Error()
+		r.NextAttemptAt = time.Now().Add(outboxBackoff(r.Attempts))
+		if d.maxAttempts > 0 && r.Attempts >= d.maxAttempts {
+			r.Status = outboxStatusStuck
+		}
+	})
+	if updateErr != nil {
+		slog.ErrorContext(ctx, "Error updating outbox event", "event_id", record.Event.ID, "error", updateErr)
+		return
+	}
+	if updated.Status == outboxStatusStuck {
+		slog.ErrorContext(ctx, "Event is stuck", "event", record.Event.Type, "event_id", record.Event.ID, "attempts", updated.Attempts, "error", err)
+		return
+	}
+	slog.WarnContext(ctx, "Error publishing event", "event", record.Event.Type, "event_id", record.Event.ID,
+		"attempt", updated.Attempts, "next_attempt_at", updated.NextAttemptAt.Format(time.RFC3339), "error", err)
+}
+
+// outboxBackoff es 1s, 2s, 4s, ... hasta outboxMaxBackoff
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxInitialDelay
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > outboxMaxBackoff {
+		backoff = outboxMaxBackoff
+	}
+	return backoff
+}
+
+// Replay vuelve a poner un evento en cola para publicarlo de inmediato
+func (d *OutboxDispatcher) Replay(seq uint64) (*OutboxRecord, error) {
+	record, err := d.store.Update(seq, func(r *OutboxRecord) {
+		r.Status = outboxStatusPending
+		r.Attempts = 0
+		r.NextAttemptAt = time.Now()
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.notify()
+	return record, nil
+}
+
+// ============================================
+// HANDLER - ADMINISTRACIÓN DEL OUTBOX
+// ============================================
+
+// handleListOutbox lista los eventos del outbox (?status=stuck|pending)
+func (g *Gateway) handleListOutbox(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != outboxStatusPending && status != outboxStatusStuck {
+		writeJSONError(w, http.StatusBadRequest, "status must be pending or stuck")
+		return
+	}
+
+	records, err := g.outbox.store.List(status)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing outbox", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Error reading outbox")
+		return
+	}
+	if records == nil {
+		records = []*OutboxRecord{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events": records,
+		"count":  len(records),
+	})
+}
+
+// handleReplayOutbox reencola un evento para publicarlo otra vez
+func (g *Gateway) handleReplayOutbox(w http.ResponseWriter, r *http.Request) {
+	var seq uint64
+	if _, err := fmt.Sscanf(mux.Vars(r)["seq"], "%d", &seq); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid event sequence")
+		return
+	}
+
+	record, err := g.outbox.Replay(seq)
+	if errors.Is(err, errOutboxNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error replaying outbox event", "seq", seq, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Error replaying event")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Outbox event queued for replay", "event_id", record.Event.ID, "seq", seq)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(record)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyPublisher falla las primeras failures llamadas
+type flakyPublisher struct {
+	mu        sync.Mutex
+	failures  int
+	attempts  int
+	published []*DomainEvent
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, event *DomainEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts++
+	if p.attempts <= p.failures {
+		return errors.New("connection refused")
+	}
+	p.published = append(p.published, event)
+	return nil
+}
+
+func (p *flakyPublisher) snapshot() (int, []*DomainEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.attempts, append([]*DomainEvent(nil), p.published...)
+}
+
+func openTestOutbox(t *testing.T) *OutboxStore {
+	t.Helper()
+	store, err := OpenOutboxStore(filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatalf("OpenOutboxStore() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestOutboxSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	store, err := OpenOutboxStore(path)
+	if err != nil {
+		t.Fatalf("OpenOutboxStore() error = %v", err)
+	}
+	if _, err := store.Add(&DomainEvent{ID: "evt-1", Type: "user.deleted", RoutingKey: "user.deleted"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	store.Close()
+
+	store, err = OpenOutboxStore(path)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer store.Close()
+
+	records, err := store.Due(time.Now(), 10)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Due() = %v, %v; want one record", records, err)
+	}
+	if records[0].Event.ID != "evt-1" || records[0].Event.RoutingKey != "user.deleted" {
+		t.Fatalf("unexpected record %+v", records[0].Event)
+	}
+}
+
+func TestOutboxDispatcherDeliversAndMarksStuck(t *testing.T) {
+	store := openTestOutbox(t)
+	publisher := &flakyPublisher{failures: 2}
+	dispatcher := NewOutboxDispatcher(store, publisher, time.Hour, 2)
+
+	if err := dispatcher.Enqueue(&DomainEvent{ID: "evt-1", Type: "user.deleted"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	// Dos fallos seguidos dejan el evento "stuck"
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		records, _ := store.Due(time.Now().Add(time.Hour), 10)
+		for _, record := range records {
+			dispatcher.dispatch(ctx, record)
+		}
+	}
+	stuck, _ := store.List(outboxStatusStuck)
+	if len(stuck) != 1 || stuck[0].Attempts != 2 || stuck[0].LastError == "" {
+		t.Fatalf("stuck events = %+v, want one with 2 attempts", stuck)
+	}
+	if due, _ := store.Due(time.Now().Add(time.Hour), 10); len(due) != 0 {
+		t.Fatalf("stuck events must not be retried automatically, got %d due", len(due))
+	}
+
+	// Replay lo vuelve a poner en cola y la siguiente entrega funciona
+	if _, err := dispatcher.Replay(stuck[0].Seq); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	dispatcher.dispatchDue(ctx)
+
+	if _, published := publisher.snapshot(); len(published) != 1 || published[0].ID != "evt-1" {
+		t.Fatalf("published = %+v", published)
+	}
+	if all, _ := store.List(""); len(all) != 0 {
+		t.Fatalf("published event still in outbox: %+v", all)
+	}
+}
+
+func TestOutboxAdminEndpoints(t *testing.T) {
+	store := openTestOutbox(t)
+	g := NewGateway(&Config{JWTSecret: testSecret})
+	g.outbox = NewOutboxDispatcher(store, &flakyPublisher{}, time.Hour, 1)
+	router := g.setupRoutes()
+
+	record, _ := store.Add(&DomainEvent{ID: "evt-1", Type: "user.deleted"})
+	store.Update(record.Seq, func(r *OutboxRecord) { r.Status = outboxStatusStuck })
+
+	adminToken := signTestToken(t, testSecret, map[string]interface{}{"sub": "root", "role": "admin", "exp": time.Now().Add(time.Hour).Unix()})
+	userToken := signTestToken(t, testSecret, map[string]interface{}{"sub": "bob", "role": "user", "exp": time.Now().Add(time.Hour).Unix()})
+
+	req := httptest.NewRequest("GET", "/admin/outbox?status=stuck", nil)
+	req.Header.Set("Authorization", "Bearer "+userToken)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("non-admin list: status = %d, want 403", rec.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/outbox?status=stuck", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var body struct {
+		Count int `json:"count"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &body) != nil || body.Count != 1 {
+		t.Fatalf("admin list: status = %d body = %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/admin/outbox/1/replay", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("replay: status = %d, want 202", rec.Code)
+	}
+	if pending, _ := store.List(outboxStatusPending); len(pending) != 1 {
+		t.Fatalf("replayed event not pending: %+v", pending)
+	}
+
+	req = httptest.NewRequest("POST", "/admin/outbox/99/replay", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("replay unknown: status = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ============================================
+// LLAMADAS A UPSTREAMS
+// ============================================
+
+// errResponseTooLarge indica que el upstream respondió más de MaxResponseBytes
+var errResponseTooLarge = errors.New("upstream response too large")
+
+// errCallNotNeeded es la causa con la que se cancelan las llamadas cuyo
+// resultado ya no sirve (por ejemplo, otra llamada requerida de la composición
+// falló)
+var errCallNotNeeded = errors.New("upstream result no longer needed")
+
+// requestBudgetHeader lleva al upstream los milisegundos que le quedan a la
+// petición antes de su deadline, para que no siga trabajando cuando el
+// gateway ya no va a esperar la respuesta
+const requestBudgetHeader = "X-Request-Timeout-Ms"
+
+// statusClientClosedRequest es el status (de nginx) que se registra cuando el
+// cliente cerró la conexión antes de recibir la respuesta
+const statusClientClosedRequest = 499
+
+// callUpstream envía req al upstream reintentando según la política de la
+// ruta cuando la petición es idempotente y su body se puede repetir
+// (GetBody). Devuelve la respuesta sin leer y cuántas llamadas se hicieron.
+func (g *Gateway) callUpstream(upstream string, req *http.Request) (*http.Response, int, error) {
+	policy := g.retryPolicy(req)
+	if !isRetryable(req) || !replayableBody(req) {
+		policy.Attempts = 1
+	}
+	budget := g.retryBudgets[upstream]
+	budget.Deposit()
+
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		resp, err := g.upstreamAttempt(upstream, req, attempt)
+		outcome := &ServiceResponse{Error: err}
+		if resp != nil {
+			outcome.StatusCode = resp.StatusCode
+		}
+		if attempt >= policy.Attempts || !shouldRetry(ctx, outcome) {
+			return resp, attempt, err
+		}
+		if !budget.Withdraw() {
+			g.metrics.observeRetry(upstream, "budget_exhausted")
+			slog.WarnContext(ctx, "Retry budget exhausted, not retrying", "upstream", upstream, "method", req.Method, "url", req.URL.String())
+			return resp, attempt, err
+		}
+		if !sleepContext(ctx, policy.backoff(attempt+1)) {
+			return resp, attempt, err
+		}
+		// La respuesta descartada se lee para poder reutilizar la conexión
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		g.metrics.observeRetry(upstream, "retried")
+		slog.InfoContext(ctx, "Retrying upstream call", "upstream", upstream, "method", req.Method, "url", req.URL.String(), "attempt", attempt+1, "max_attempts", policy.Attempts)
+	}
+}
+
+// replayableBody indica si el body de req se puede volver a enviar
+func replayableBody(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// upstreamAttempt hace una sola llamada al upstream dentro de su propio span
+// de cliente, así cada llamada (y cada reintento) es un hijo distinto en la
+// traza. El span termina cuando se cierra el body de la respuesta.
+func (g *Gateway) upstreamAttempt(upstream string, req *http.Request, attempt int) (*http.Response, error) {
+	ctx, span := g.tracer().Start(req.Context(), req.Method+" "+upstream,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(req.URL.String()),
+			upstreamAttr.String(upstream),
+		))
+	if attempt > 1 {
+		span.SetAttributes(semconv.HTTPRequestResendCount(attempt - 1))
+	}
+	if username := mux.Vars(req)["username"]; username != "" {
+		span.SetAttributes(usernameAttr.String(username))
+	}
+
+	out := req.Clone(ctx)
+	out.RequestURI = ""
+	if attempt > 1 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			span.End()
+			return nil, err
+		}
+		out.Body = body
+	}
+	setTraceHeaders(ctx, out.Header)
+	setBudgetHeader(ctx, out.Header)
+
+	resp, err := g.roundTrip(upstream, out)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	resp.Body = &spanBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+// roundTrip envía la petición pasando por el circuit breaker del upstream. El
+// resultado se registra al recibir las cabeceras; el body queda sin leer.
+func (g *Gateway) roundTrip(upstream string, req *http.Request) (*http.Response, error) {
+	// Si el upstream viene fallando el circuit breaker corta sin esperar
+	breaker := g.breakers[upstream]
+	generation, err := breaker.Allow()
+	if err != nil {
+		g.metrics.observeRejected(upstream)
+		return nil, fmt.Errorf("%s: %w", upstream, err)
+	}
+
+	start := time.Now()
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		g.metrics.observeUpstream(upstream, req.Method, 0, time.Since(start), err)
+		if upstreamCanceled(err) {
+			reason := cancelReason(req.Context())
+			g.metrics.observeCanceled(upstream, reason)
+			slog.InfoContext(req.Context(), "Upstream call cancelled", "upstream", upstream, "method", req.Method,
+				"url", req.URL.String(), "reason", reason, "elapsed_ms", time.Since(start).Milliseconds())
+		}
+		breaker.Record(generation, callOutcome(0, err))
+		return nil, err
+	}
+	g.metrics.observeUpstream(upstream, req.Method, resp.StatusCode, time.Since(start), nil)
+	breaker.Record(generation, callOutcome(resp.StatusCode, nil))
+	return resp, nil
+}
+
+// setBudgetHeader pone en header el tiempo que le queda a ctx. Sin deadline
+// se quita, así no se reenvía el valor que haya mandado el cliente.
+func setBudgetHeader(ctx context.Context, header http.Header) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		header.Del(requestBudgetHeader)
+		return
+	}
+	remaining := time.Until(deadline).Milliseconds()
+	if remaining < 1 {
+		remaining = 1
+	}
+	header.Set(requestBudgetHeader, strconv.FormatInt(remaining, 10))
+}
+
+// upstreamCanceled indica si la llamada se cortó porque se canceló su
+// contexto. No es una falla del upstream; un deadline vencido sí lo es.
+// net/http devuelve la causa de la cancelación si la hay.
+func upstreamCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, errCallNotNeeded)
+}
+
+// cancelReason explica por qué se canceló ctx: la llamada ya no hacía falta o
+// el cliente cerró la conexión
+func cancelReason(ctx context.Context) string {
+	if errors.Is(context.Cause(ctx), errCallNotNeeded) {
+		return "not_needed"
+	}
+	return "client_disconnected"
+}
+
+// withRouteTimeout limita el tiempo que un handler puede esperar a los
+// upstreams. Las rutas de la tabla usan el timeout de su configuración.
+func withRouteTimeout(timeout time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// spanBody termina el span de la llamada cuando se termina de leer la respuesta
+type spanBody struct {
+	io.ReadCloser
+	span trace.Span
+	once sync.Once
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.span.End() })
+	return err
+}
+
+// ============================================
+// LÍMITES DE TAMAÑO
+// ============================================
+
+// limitRequestBody corta los bodies de más de MaxRequestBytes: si el cliente
+// declara el tamaño se responde 413 de inmediato y si no, la lectura falla con
+// *http.MaxBytesError al pasar el límite
+func (g *Gateway) limitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := g.config.MaxRequestBytes
+		if limit > 0 && r.Body != nil {
+			if r.ContentLength > limit {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readRequestBody lee el body completo para los handlers que lo necesitan.
+// Responde 413 si supera el límite y 400 si no se pudo leer.
+func readRequestBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Body == nil {
+		return nil, true
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		} else {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+		}
+		return nil, false
+	}
+	return body, true
+}
+
+// readLimited lee una respuesta de upstream de hasta limit bytes (0 = sin
+// límite); si el upstream ya declara un tamaño mayor no se lee nada
+func readLimited(body io.Reader, contentLength, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(body)
+	}
+	if contentLength > limit {
+		return nil, errResponseTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errResponseTooLarge
+	}
+	return data, nil
+}
+
+// limitedBody falla con errResponseTooLarge al pasar el límite. Como la
+// respuesta ya empezó a enviarse, el proxy corta la conexión con el cliente.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errResponseTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), errResponseTooLarge
+	}
+	return n, err
+}
+
+// writeUpstreamError responde 502 si el upstream devolvió una respuesta
+// demasiado grande, 499 si la llamada se canceló (el cliente ya no está, pero
+// así no cuenta como 5xx en las métricas) y 503 en el resto de fallos
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	if upstreamCanceled(err) {
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	if errors.Is(err, errResponseTooLarge) {
+		http.Error(w, "Upstream response too large", http.StatusBadGateway)
+		return
+	}
+	http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+}
+
+// ============================================
+// PROXY CON STREAMING - RUTAS DE LA TABLA
+// ============================================
+
+type proxyTargetKey struct{}
+
+// newRouteProxy crea el reverse proxy de una ruta de la tabla. Los bodies van
+// en streaming en los dos sentidos; solo se guardan en memoria los de
+// peticiones que se pueden reintentar.
+func (g *Gateway) newRouteProxy(route RouteConfig) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = pr.In.Context().Value(proxyTargetKey{}).(*url.URL)
+			pr.Out.Host = ""
+			// ReverseProxy ya quitó las cabeceras hop-by-hop y las de reenvío
+			g.setForwardedHeaders(pr.Out.Header, pr.In)
+		},
+		Transport:     &upstreamTransport{gateway: g, upstream: route.Upstream},
+		FlushInterval: -1,
+		ModifyResponse: func(resp *http.Response) error {
+			limit := g.config.MaxResponseBytes
+			if limit > 0 {
+				if resp.ContentLength > limit {
+					return errResponseTooLarge
+				}
+				resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: limit}
+			}
+			slog.InfoContext(resp.Request.Context(), "Upstream responded", "route_name", route.Name, "upstream", route.Upstream,
+				"status", resp.StatusCode, "attempts", resp.Header.Get(attemptsHeader))
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			var callErr *upstreamCallError
+			if errors.As(err, &callErr) {
+				w.Header().Set(attemptsHeader, strconv.Itoa(callErr.attempts))
+			}
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+				return
+			}
+			if !upstreamCanceled(err) {
+				slog.ErrorContext(r.Context(), "Error proxying request", "route_name", route.Name, "upstream", route.Upstream, "error", err)
+			}
+			writeUpstreamError(w, err)
+		},
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// upstreamTransport conecta el reverse proxy con callUpstream (reintentos,
+// circuit breaker, métricas y trazas)
+type upstreamTransport struct {
+	gateway  *Gateway
+	upstream string
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Para reintentar hay que poder reenviar el body: solo en ese caso se lee
+	// completo (ya viene limitado por limitRequestBody)
+	if isRetryable(req) && t.gateway.retryPolicy(req).Attempts > 1 && !replayableBody(req) {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	resp, attempts, err := t.gateway.callUpstream(t.upstream, req)
+	if err != nil {
+		return nil, &upstreamCallError{attempts: attempts, err: err}
+	}
+	resp.Header.Set(attemptsHeader, strconv.Itoa(attempts))
+	return resp, nil
+}
+
+// upstreamCallError guarda los intentos hechos para la cabecera de la
+// respuesta de error
+type upstreamCallError struct {
+	attempts int
+	err      error
+}
+
+func (e *upstreamCallError) Error() string { return e.err.Error() }
+func (e *upstreamCallError) Unwrap() error { return e.err }
+
+// proxyTo guarda en el contexto la URL de destino para el Rewrite del proxy
+func proxyTo(ctx context.Context, target *url.URL) context.Context {
+	return context.WithValue(ctx, proxyTargetKey{}, target)
+}
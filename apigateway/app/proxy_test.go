@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func streamingGateway(upstreamURL string, config Config) *Gateway {
+	config.ProfileServiceURL = upstreamURL
+	g := NewGateway(&config)
+	g.routeTable = &RouteTable{Routes: []RouteConfig{
+		{Name: "search", Method: "GET", Path: "/search", Upstream: "profiles", UpstreamPath: "/search", Timeout: 5 * time.Second},
+		{Name: "upload", Method: "POST", Path: "/upload", Upstream: "profiles", UpstreamPath: "/upload", Timeout: 5 * time.Second},
+	}}
+	return g
+}
+
+func TestRouteProxyStreamsAndFlushes(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("second\n"))
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	// Con toda la cadena de middlewares, que envuelven el ResponseWriter
+	g := streamingGateway(upstream.URL, Config{})
+	router := g.setupRoutes()
+	server := httptest.NewServer(g.tracingMiddleware(router, g.loggingMiddleware(router, g.metricsMiddleware(router, router))))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/search")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	// La primera línea llega antes de que el upstream termine
+	line := make(chan string, 1)
+	go func() {
+		text, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		line <- text
+	}()
+	select {
+	case got := <-line:
+		if got != "first\n" {
+			t.Fatalf("first chunk = %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("response was buffered instead of streamed")
+	}
+	if resp.Header.Get(attemptsHeader) != "1" {
+		t.Errorf("%s = %q, want 1", attemptsHeader, resp.Header.Get(attemptsHeader))
+	}
+}
+
+func TestRouteProxyRequestSizeLimit(t *testing.T) {
+	var calls, received atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		n, _ := io.Copy(io.Discard, r.Body)
+		received.Store(n)
+	}))
+	defer upstream.Close()
+	router := streamingGateway(upstream.URL, Config{MaxRequestBytes: 1024}).setupRoutes()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("a", 1000))))
+	if rec.Code != http.StatusOK || received.Load() != 1000 {
+		t.Fatalf("upload under limit: status %d, upstream got %d bytes", rec.Code, received.Load())
+	}
+
+	// Tamaño declarado mayor al límite: 413 sin llamar al upstream
+	calls.Store(0)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("a", 2048))))
+	if rec.Code != http.StatusRequestEntityTooLarge || calls.Load() != 0 {
+		t.Fatalf("declared oversized upload: status %d after %d upstream calls, want 413 and none", rec.Code, calls.Load())
+	}
+
+	// Sin Content-Length el límite se aplica mientras se envía
+	req := httptest.NewRequest("POST", "/upload", io.MultiReader(strings.NewReader(strings.Repeat("a", 2048))))
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("chunked oversized upload: status %d, want 413", rec.Code)
+	}
+}
+
+func TestRouteProxyResponseSizeLimit(t *testing.T) {
+	body := strings.Repeat("x", 4096)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chunked") != "" {
+			w.Write([]byte(body[:1024]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(body[1024:]))
+			return
+		}
+		w.Header().Set("Content-Length", "4096")
+		w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+	g := streamingGateway(upstream.URL, Config{MaxResponseBytes: 2048})
+	router := g.setupRoutes()
+
+	// Tamaño declarado: 502 antes de enviar nada
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/search", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("declared oversized response: status %d, want 502", rec.Code)
+	}
+
+	// En streaming la respuesta se corta al pasar el límite
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/search?chunked=1", nil))
+	if rec.Body.Len() > 2048 {
+		t.Fatalf("streamed %d bytes, want at most 2048", rec.Body.Len())
+	}
+
+	// Los handlers que leen la respuesta completa también la limitan
+	resp := g.proxyRequest("profiles", upstream.URL+"/search?chunked=1", httptest.NewRequest("GET", "/", nil), nil)
+	if !errors.Is(resp.Error, errResponseTooLarge) {
+		t.Fatalf("proxyRequest() error = %v, want errResponseTooLarge", resp.Error)
+	}
+}
+
+func TestClientDisconnectCancelsUpstreamCall(t *testing.T) {
+	received := make(chan http.Header, 1)
+	cancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer upstream.Close()
+	g := streamingGateway(upstream.URL, Config{})
+	router := g.setupRoutes()
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/search", nil).WithContext(ctx)
+	req.Header.Set(requestBudgetHeader, "999999")
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	// El upstream recibe el tiempo que le queda a la ruta, no el del cliente
+	header := <-received
+	if ms, err := strconv.Atoi(header.Get(requestBudgetHeader)); err != nil || ms <= 0 || ms > 5000 {
+		t.Errorf("%s = %q, want at most the 5s route timeout", requestBudgetHeader, header.Get(requestBudgetHeader))
+	}
+
+	disconnect()
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream call kept running after the client disconnected")
+	}
+	<-done
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("status = %d, want %d", rec.Code, statusClientClosedRequest)
+	}
+	if got := testutil.ToFloat64(g.metrics.upstreamCanceled.WithLabelValues("profiles", "client_disconnected")); got != 1 {
+		t.Errorf("client_disconnected cancellations = %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(g.metrics.upstreamErrors); got != 0 {
+		t.Errorf("cancelled call counted as %d upstream errors", got)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/yaml.v3"
+)
+
+// ============================================
+// RATE LIMITING - TOKEN BUCKET
+// ============================================
+
+// RateLimit es un token bucket: se reponen Requests fichas cada Period y se
+// acumulan como mucho Burst
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// perMillisecond es la velocidad de reposición en fichas por milisegundo
+func (l RateLimit) perMillisecond() float64 {
+	return float64(l.Requests) / float64(l.Period.Milliseconds())
+}
+
+// RateLimitResult es el estado del bucket después de pedir una ficha
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // cuándo habrá una ficha (solo si !Allowed)
+	Reset      time.Duration // cuándo vuelve a estar lleno
+}
+
+// bucketResult calcula las cabeceras a partir de las fichas que quedan
+func bucketResult(allowed bool, tokens float64, limit RateLimit) RateLimitResult {
+	rate := limit.perMillisecond()
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration(math.Ceil((float64(limit.Burst)-tokens)/rate)) * time.Millisecond,
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration(math.Ceil((1-tokens)/rate)) * time.Millisecond
+	}
+	return result
+}
+
+// RateLimitStore guarda los buckets. La implementación en memoria sirve para
+// una réplica; con varias réplicas se usa Redis para compartir los contadores.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryRateLimitStore guarda los buckets en memoria del proceso
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*memoryBucket{}, now: time.Now}
+}
+
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = bucket
+	}
+	elapsed := float64(now.Sub(bucket.updated).Milliseconds())
+	bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+elapsed*limit.perMillisecond())
+	bucket.updated = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	return bucketResult(allowed, bucket.tokens, limit), nil
+}
+
+// sweep borra los buckets que llevan un rato sin uso, como mucho una vez por
+// minuto. Un bucket sin uso durante 10 minutos ya estaría lleno de nuevo.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.updated) > 10*time.Minute {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// redisTokenBucket aplica el token bucket de forma atómica en Redis. Usa el
+// reloj de Redis para que todas las réplicas midan igual. Devuelve las fichas
+// como texto porque Redis trunca los números de Lua a enteros.
+var redisTokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisRateLimitStore comparte los buckets entre réplicas usando Redis (o
+// cualquier servidor compatible con EVALSHA)
+type RedisRateLimitStore struct {
+	client redis.Scripter
+}
+
+func NewRedisRateLimitStore(client redis.Scripter) *RedisRateLimitStore {
+	return &RedisRateLimitStore{client: client}
+}
+
+func (s *RedisRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	rate := strconv.FormatFloat(limit.perMillisecond(), 'g', -1, 64)
+	values, err := redisTokenBucket.Run(ctx, s.client, []string{key}, rate, limit.Burst).Slice()
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("rate limit script: %w", err)
+	}
+	if len(values) != 2 {
+		return RateLimitResult{}, fmt.Errorf("rate limit script: unexpected reply %v", values)
+	}
+	allowed, _ := values[0].(int64)
+	tokensText, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(tokensText, 64)
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("rate limit script: bad tokens %q", tokensText)
+	}
+	return bucketResult(allowed == 1, tokens, limit), nil
+}
+
+// ============================================
+// RATE LIMITING - GRUPOS DE RUTAS
+// ============================================
+
+// RateLimitGroup aplica un límite a un conjunto de rutas. Paths son
+// plantillas de mux; un * al final significa "cualquier ruta con ese prefijo".
+type RateLimitGroup struct {
+	Name  string
+	Paths []string
+	Limit RateLimit
+}
+
+func (rg *RateLimitGroup) matches(template string) bool {
+	return matchesRouteTemplate(rg.Paths, template)
+}
+
+// RateLimiter decide qué grupo aplica a cada petición y consulta el store
+type RateLimiter struct {
+	groups []*RateLimitGroup
+	store  RateLimitStore
+}
+
+// groupFor devuelve el primer grupo que cubre la ruta, o nil si no tiene límite
+func (rl *RateLimiter) groupFor(template string) *RateLimitGroup {
+	for _, group := range rl.groups {
+		if group.matches(template) {
+			return group
+		}
+	}
+	return nil
+}
+
+// LoadRateLimitGroups lee y valida el archivo de límites (YAML o JSON)
+func LoadRateLimitGroups(path string) ([]*RateLimitGroup, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rate limits %s: %w", path, err)
+	}
+	return parseRateLimitGroups(data)
+}
+
+func parseRateLimitGroups(data []byte) ([]*RateLimitGroup, error) {
+	var raw struct {
+		Groups []struct {
+			Name     string   `yaml:"name"`
+			Paths    []string `yaml:"paths"`
+			Requests int      `yaml:"requests"`
+			Period   string   `yaml:"period"`
+			Burst    int      `yaml:"burst"`
+		} `yaml:"groups"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing rate limits: %w", err)
+	}
+
+	var groups []*RateLimitGroup
+	for i, entry := range raw.Groups {
+		if entry.Name == "" || len(entry.Paths) == 0 {
+			return nil, fmt.Errorf("rate limit group %d: name and paths are required", i)
+		}
+		period, err := time.ParseDuration(entry.Period)
+		if err != nil || period < time.Millisecond {
+			return nil, fmt.Errorf("rate limit group %d (%s): invalid period %q", i, entry.Name, entry.Period)
+		}
+		if entry.Requests <= 0 {
+			return nil, fmt.Errorf("rate limit group %d (%s): requests must be positive", i, entry.Name)
+		}
+		burst := entry.Burst
+		if burst <= 0 {
+			burst = entry.Requests
+		}
+		groups = append(groups, &RateLimitGroup{
+			Name:  entry.Name,
+			Paths: entry.Paths,
+			Limit: RateLimit{Requests: entry.Requests, Period: period, Burst: burst},
+		})
+	}
+	return groups, nil
+}
+
+// ============================================
+// MIDDLEWARE - RATE LIMITING
+// ============================================
+
+// rateLimitSubject identifica al cliente: el subject si trae un token válido,
+// si no la IP. Devuelve r con el token validado en el contexto, para que
+// requireAuth no lo vuelva a validar.
+func (g *Gateway) rateLimitSubject(r *http.Request) (string, *http.Request) {
+	if token, ok := bearerToken(r.Header.Get("Authorization")); ok {
+		var claims *TokenClaims
+		var err error
+		r, claims, err = g.validateRequestToken(r, token)
+		if err == nil && claims.Subject != "" {
+			return "sub:" + claims.Subject, r
+		}
+	}
+	return "ip:" + g.clientIP(r), r
+}
+
+// rateLimitMiddleware limita las peticiones por grupo de rutas. Si el store
+// falla se deja pasar la petición: es preferible a tumbar el gateway.
+func (g *Gateway) rateLimitMiddleware(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.rateLimiter == nil || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		group := g.rateLimiter.groupFor(routeTemplate(router, r))
+		if group == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		subject, r := g.rateLimitSubject(r)
+		key := "ratelimit:" + group.Name + ":" + subject
+		result, err := g.rateLimiter.store.Take(r.Context(), key, group.Limit)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Rate limit store error, allowing request", "group", group.Name, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := group.Limit
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", limit.Requests, ceilSeconds(limit.Period), limit.Burst))
+
+		if !result.Allowed {
+			g.metrics.rateLimited.WithLabelValues(group.Name).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			writeJSONError(w, http.StatusTooManyRequests, "Too many requests, retry later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ceilSeconds redondea hacia arriba a segundos enteros (mínimo 1 si d > 0)
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
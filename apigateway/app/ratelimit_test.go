@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLoadRateLimitGroupsDefaultFile(t *testing.T) {
+	groups, err := LoadRateLimitGroups("../config/ratelimits.yaml")
+	if err != nil {
+		t.Fatalf("LoadRateLimitGroups() error = %v", err)
+	}
+	rl := &RateLimiter{groups: groups}
+	if group := rl.groupFor("/api/v1/auth/login"); group == nil || group.Name != "login" {
+		t.Fatalf("login route group = %+v", group)
+	}
+	if group := rl.groupFor("/api/v1/users/{username}/profile"); group == nil || group.Name != "api" {
+		t.Fatalf("users route group = %+v", group)
+	}
+	if group := rl.groupFor("/health"); group != nil {
+		t.Fatalf("/health should not be limited, got %s", group.Name)
+	}
+}
+
+func TestMemoryRateLimitStoreRefills(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Requests: 1, Period: time.Second, Burst: 2}
+
+	for i, want := range []bool{true, true, false} {
+		result, _ := store.Take(context.Background(), "k", limit)
+		if result.Allowed != want {
+			t.Fatalf("take %d: allowed = %v, want %v", i, result.Allowed, want)
+		}
+	}
+
+	now = now.Add(time.Second)
+	if result, _ := store.Take(context.Background(), "k", limit); !result.Allowed {
+		t.Fatal("bucket did not refill after one period")
+	}
+}
+
+func TestRateLimitMiddlewareByIPAndSubject(t *testing.T) {
+	g := NewGateway(&Config{JWTSecret: testSecret})
+	g.rateLimiter = &RateLimiter{
+		groups: []*RateLimitGroup{{Name: "login", Paths: []string{"/health"}, Limit: RateLimit{Requests: 1, Period: time.Minute, Burst: 1}}},
+		store:  NewMemoryRateLimitStore(),
+	}
+	router := g.setupRoutes()
+	handler := g.rateLimitMiddleware(router, router)
+
+	get := func(remoteAddr, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/health", nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("10.0.0.1:1111", ""); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("first request: %d %v", rec.Code, rec.Header())
+	}
+
+	rec := get("10.0.0.1:2222", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request from same IP: status = %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("Retry-After = %q, want 60", rec.Header().Get("Retry-After"))
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] == "" {
+		t.Fatalf("429 body = %s", rec.Body.String())
+	}
+
+	if rec := get("10.0.0.2:1111", ""); rec.Code != http.StatusOK {
+		t.Fatalf("other IP: status = %d", rec.Code)
+	}
+
+	// Con token el límite es por usuario, no por IP
+	token := signTestToken(t, testSecret, map[string]interface{}{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+	if rec := get("10.0.0.1:3333", token); rec.Code != http.StatusOK {
+		t.Fatalf("authenticated request from limited IP: status = %d", rec.Code)
+	}
+}
+
+func TestRateLimitValidatesTokenOnce(t *testing.T) {
+	g := NewGateway(&Config{JWTSecret: testSecret})
+	g.rateLimiter = &RateLimiter{
+		groups: []*RateLimitGroup{{Name: "profiles", Paths: []string{"/api/v1/profiles/me"}, Limit: RateLimit{Requests: 10, Period: time.Minute, Burst: 10}}},
+		store:  NewMemoryRateLimitStore(),
+	}
+	// now se consulta una vez por cada token con firma válida
+	validations := 0
+	g.jwtValidator.now = func() time.Time {
+		validations++
+		return time.Now()
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v1/profiles/me", g.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	handler := g.rateLimitMiddleware(router, router)
+
+	token := signTestToken(t, testSecret, map[string]interface{}{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+	req := httptest.NewRequest("GET", "/api/v1/profiles/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || validations != 1 {
+		t.Fatalf("status = %d, validations = %d, want 204 with one validation", rec.Code, validations)
+	}
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	store := NewRedisRateLimitStore(client)
+	limit := RateLimit{Requests: 2, Period: time.Minute, Burst: 2}
+
+	for i, want := range []bool{true, true, false} {
+		result, err := store.Take(context.Background(), "ratelimit:test", limit)
+		if err != nil {
+			t.Fatalf("Take() error = %v", err)
+		}
+		if result.Allowed != want {
+			t.Fatalf("take %d: allowed = %v, want %v", i, result.Allowed, want)
+		}
+		if !want && result.RetryAfter <= 0 {
+			t.Fatalf("rejected take without RetryAfter: %+v", result)
+		}
+	}
+}
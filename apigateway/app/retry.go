@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ============================================
+// REINTENTOS CON BACKOFF
+// ============================================
+
+// attemptsHeader informa al cliente cuántas llamadas a upstreams hizo el
+// gateway para responder, contando los reintentos
+const attemptsHeader = "X-Upstream-Attempts"
+
+// RetryPolicy define cuántas veces se intenta una llamada y cuánto se espera
+// entre intentos. Attempts 0 significa "usar la política por defecto".
+type RetryPolicy struct {
+	Attempts       int           // intentos totales, incluido el primero
+	InitialBackoff time.Duration // espera base antes del primer reintento
+	MaxBackoff     time.Duration // tope de la espera
+}
+
+// backoff devuelve la espera antes del intento attempt (2, 3, ...) con
+// jitter completo: un valor al azar entre 0 y base*2^(attempt-2)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.InitialBackoff
+	for i := 2; i < attempt && ceiling < p.MaxBackoff; i++ {
+		ceiling *= 2
+	}
+	if ceiling > p.MaxBackoff {
+		ceiling = p.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+type retryPolicyKey struct{}
+
+// withRetryPolicy guarda en el contexto la política de la ruta que atiende r
+func withRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// retryPolicy devuelve la política de la ruta o, si no tiene, la del gateway
+func (g *Gateway) retryPolicy(r *http.Request) RetryPolicy {
+	if policy, ok := r.Context().Value(retryPolicyKey{}).(RetryPolicy); ok && policy.Attempts > 0 {
+		return policy
+	}
+	if g.config.Retry.Attempts > 0 {
+		return g.config.Retry
+	}
+	return RetryPolicy{Attempts: 1}
+}
+
+// isRetryable indica si la petición se puede repetir sin efectos duplicados:
+// métodos idempotentes o peticiones que traen Idempotency-Key
+func isRetryable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != ""
+}
+
+// shouldRetry decide si el resultado de un intento merece otro. Se reintenta
+// ante errores de conexión y 502/503/504; no si el circuito está abierto o el
+// cliente ya se fue.
+func shouldRetry(ctx context.Context, resp *ServiceResponse) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if resp.Error != nil {
+		return !errors.Is(resp.Error, errCircuitOpen)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// sleepContext espera d o hasta que se cancele ctx
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// setAttemptsHeader suma los intentos de todas las llamadas hechas para
+// responder y los pone en la respuesta
+func setAttemptsHeader(w http.ResponseWriter, responses ...*ServiceResponse) {
+	total := 0
+	for _, resp := range responses {
+		if resp != nil {
+			total += resp.Attempts
+		}
+	}
+	if total > 0 {
+		w.Header().Set(attemptsHeader, strconv.Itoa(total))
+	}
+}
+
+// ============================================
+// PRESUPUESTO DE REINTENTOS
+// ============================================
+
+// RetryBudget limita los reintentos a una fracción de las llamadas, para que
+// durante una caída los reintentos no multipliquen la carga sobre el
+// upstream. Cada llamada deposita Ratio fichas y cada reintento gasta una;
+// nunca se acumulan más de Max.
+type RetryBudget struct {
+	ratio float64
+	max   float64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+func NewRetryBudget(ratio float64, max int) *RetryBudget {
+	if ratio <= 0 {
+		ratio = 0.2
+	}
+	if max <= 0 {
+		max = 10
+	}
+	return &RetryBudget{ratio: ratio, max: float64(max), tokens: float64(max)}
+}
+
+// Deposit se llama por cada llamada original (no por los reintentos)
+func (b *RetryBudget) Deposit() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+// Withdraw gasta una ficha para un reintento; false si no quedan
+func (b *RetryBudget) Withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// newRetryBudgets crea un presupuesto por cada upstream conocido
+func newRetryBudgets(ratio float64, max int) map[string]*RetryBudget {
+	budgets := make(map[string]*RetryBudget, len(knownUpstreams))
+	for name := range knownUpstreams {
+		budgets[name] = NewRetryBudget(ratio, max)
+	}
+	return budgets
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyUpstream corta la conexión en las primeras failures llamadas
+func flakyUpstream(t *testing.T, failures int32) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("hijack: %v", err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func retryGateway(upstreamURL string, budgetMax int) *Gateway {
+	g := NewGateway(&Config{ProfileServiceURL: upstreamURL, RetryBudgetMax: budgetMax})
+	g.routeTable = &RouteTable{Routes: []RouteConfig{
+		{Name: "read", Method: "GET", Path: "/read", Upstream: "profiles", UpstreamPath: "/x", Timeout: time.Second,
+			Retry: RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}},
+		{Name: "write", Method: "POST", Path: "/write", Upstream: "profiles", UpstreamPath: "/x", Timeout: time.Second,
+			Retry: RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}},
+	}}
+	return g
+}
+
+func TestRetriesIdempotentRequests(t *testing.T) {
+	upstream, calls := flakyUpstream(t, 2)
+	router := retryGateway(upstream.URL, 10).setupRoutes()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/read", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 after retries", rec.Code)
+	}
+	if got := rec.Header().Get(attemptsHeader); got != "3" {
+		t.Fatalf("%s = %q, want 3", attemptsHeader, got)
+	}
+	if got := atomic.LoadInt32(calls); got != 3 {
+		t.Fatalf("upstream calls = %d, want 3", got)
+	}
+}
+
+func TestPostRetriedOnlyWithIdempotencyKey(t *testing.T) {
+	upstream, calls := flakyUpstream(t, 1)
+	router := retryGateway(upstream.URL, 10).setupRoutes()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/write", strings.NewReader(`{}`)))
+	if rec.Code != http.StatusServiceUnavailable || atomic.LoadInt32(calls) != 1 {
+		t.Fatalf("POST without key: status %d after %d calls, want 503 after 1", rec.Code, atomic.LoadInt32(calls))
+	}
+
+	upstream, calls = flakyUpstream(t, 1)
+	router = retryGateway(upstream.URL, 10).setupRoutes()
+	req := httptest.NewRequest("POST", "/write", strings.NewReader(`{}`))
+	req.Header.Set("Idempotency-Key", "abc")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || atomic.LoadInt32(calls) != 2 {
+		t.Fatalf("POST with key: status %d after %d calls, want 200 after 2", rec.Code, atomic.LoadInt32(calls))
+	}
+}
+
+func TestRetryBudgetStopsRetries(t *testing.T) {
+	upstream, calls := flakyUpstream(t, 1000)
+	g := retryGateway(upstream.URL, 1)
+	router := g.setupRoutes()
+
+	for i := 0; i < 3; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/read", nil))
+	}
+
+	// El presupuesto empieza con una ficha: 3 llamadas originales + 1 reintento
+	if got := atomic.LoadInt32(calls); got != 4 {
+		t.Fatalf("upstream calls = %d, want 4", got)
+	}
+}
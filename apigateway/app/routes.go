@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+// ============================================
+// TABLA DE RUTAS DECLARATIVA
+// ============================================
+
+const defaultRouteTimeout = 10 * time.Second
+
+// RouteConfig describe una ruta que el gateway reenvía tal cual a un upstream
+type RouteConfig struct {
+	Name         string        `yaml:"name"`
+	Method       string        `yaml:"method"`
+	Path         string        `yaml:"path"`
+	Upstream     string        `yaml:"upstream"`
+	UpstreamPath string        `yaml:"upstream_path"`
+	AuthRequired bool          `yaml:"auth"`
+	LoginLockout bool          `yaml:"lockout"`
+	Timeout      time.Duration `yaml:"-"`
+	Retry        RetryPolicy   `yaml:"-"`
+	// ParamPatterns reemplaza PATH_PARAM_PATTERN para algunas variables
+	ParamPatterns map[string]*regexp.Regexp `yaml:"-"`
+}
+
+// RouteTable es el contenido del archivo de rutas (YAML o JSON)
+type RouteTable struct {
+	Routes []RouteConfig
+}
+
+// LoadRouteTable lee y valida el archivo de rutas. Como JSON es YAML válido,
+// el mismo parser sirve para ambos formatos.
+func LoadRouteTable(path string) (*RouteTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading route table %s: %w", path, err)
+	}
+	return parseRouteTable(data)
+}
+
+func parseRouteTable(data []byte) (*RouteTable, error) {
+	var raw struct {
+		Routes []struct {
+			RouteConfig `yaml:",inline"`
+			Timeout     string `yaml:"timeout"`
+			Retry       *struct {
+				Attempts   int    `yaml:"attempts"`
+				Backoff    string `yaml:"backoff"`
+				MaxBackoff string `yaml:"max_backoff"`
+			} `yaml:"retry"`
+			Params map[string]string `yaml:"params"`
+		} `yaml:"routes"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing route table: %w", err)
+	}
+
+	table := &RouteTable{}
+	for i, entry := range raw.Routes {
+		route := entry.RouteConfig
+		route.Method = strings.ToUpper(route.Method)
+		route.Timeout = defaultRouteTimeout
+		if entry.Timeout != "" {
+			timeout, err := time.ParseDuration(entry.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("route %d (%s): invalid timeout %q", i, route.Name, entry.Timeout)
+			}
+			route.Timeout = timeout
+		}
+		if entry.Retry != nil {
+			retry := RetryPolicy{Attempts: entry.Retry.Attempts}
+			for _, d := range []struct {
+				raw    string
+				target *time.Duration
+			}{{entry.Retry.Backoff, &retry.InitialBackoff}, {entry.Retry.MaxBackoff, &retry.MaxBackoff}} {
+				if d.raw == "" {
+					continue
+				}
+				value, err := time.ParseDuration(d.raw)
+				if err != nil {
+					return nil, fmt.Errorf("route %d (%s): invalid retry backoff %q", i, route.Name, d.raw)
+				}
+				*d.target = value
+			}
+			route.Retry = retry
+		}
+		for name, pattern := range entry.Params {
+			re, err := compilePathParamPattern(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("route %d (%s): %w", i, route.Name, err)
+			}
+			if route.ParamPatterns == nil {
+				route.ParamPatterns = map[string]*regexp.Regexp{}
+			}
+			route.ParamPatterns[name] = re
+		}
+		if err := route.validate(); err != nil {
+			return nil, fmt.Errorf("route %d (%s): %w", i, route.Name, err)
+		}
+		table.Routes = append(table.Routes, route)
+	}
+	return table, nil
+}
+
+func (rc *RouteConfig) validate() error {
+	switch rc.Method {
+	case "GET", "POST", "PUT", "PATCH", "DELETE":
+	default:
+		return fmt.Errorf("unsupported method %q", rc.Method)
+	}
+	if !strings.HasPrefix(rc.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	if !strings.HasPrefix(rc.UpstreamPath, "/") {
+		return fmt.Errorf("upstream_path must start with /")
+	}
+	if _, ok := knownUpstreams[rc.Upstream]; !ok {
+		return fmt.Errorf("unknown upstream %q", rc.Upstream)
+	}
+	pathVars := templateVars(rc.Path)
+	for _, name := range templateVars(rc.UpstreamPath) {
+		if !slices.Contains(pathVars, name) {
+			return fmt.Errorf("upstream_path variable {%s} is not in path", name)
+		}
+	}
+	if rc.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	if rc.Retry.Attempts < 0 {
+		return fmt.Errorf("retry attempts must not be negative")
+	}
+	if rc.Retry.MaxBackoff > 0 && rc.Retry.MaxBackoff < rc.Retry.InitialBackoff {
+		return fmt.Errorf("retry max_backoff must not be lower than backoff")
+	}
+	return nil
+}
+
+// knownUpstreams son los nombres de upstream que se pueden usar en la tabla
+var knownUpstreams = map[string]struct{}{
+	"auth":         {},
+	"profiles":     {},
+	"orchestrator": {},
+}
+
+// upstreamURL devuelve la URL base de un upstream por nombre. Si hay
+// descubrimiento por Consul se usa una instancia sana; si no, la URL fija.
+func (g *Gateway) upstreamURL(name string) string {
+	if g.discovery != nil {
+		if instanceURL, ok := g.discovery.Resolve(name); ok {
+			return instanceURL
+		}
+	}
+	return g.staticUpstreamURL(name)
+}
+
+func (g *Gateway) staticUpstreamURL(name string) string {
+	switch name {
+	case "auth":
+		return g.config.AuthServiceURL
+	case "profiles":
+		return g.config.ProfileServiceURL
+	case "orchestrator":
+		return g.config.OrchestratorURL
+	}
+	return ""
+}
+
+// matchesRouteTemplate indica si la plantilla de mux está en patterns; un *
+// al final de un patrón cubre cualquier plantilla con ese prefijo
+func matchesRouteTemplate(patterns []string, template string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(template, prefix) {
+				return true
+			}
+		} else if template == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================
+// URLS DE UPSTREAM - PARÁMETROS DE RUTA
+// ============================================
+
+// defaultPathParamPattern acepta lo que puede tener un username o un id
+const defaultPathParamPattern = `[A-Za-z0-9._@+-]{1,128}`
+
+var defaultPathParamRegexp = regexp.MustCompile(`^(?:` + defaultPathParamPattern + `)$`)
+
+// compilePathParamPattern ancla el patrón: el valor completo debe cumplirlo
+func compilePathParamPattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path parameter pattern %q: %w", pattern, err)
+	}
+	return re, nil
+}
+
+// PathParamError indica que un parámetro de la ruta no se puede usar para
+// armar la URL del upstream; el handler responde 400
+type PathParamError struct {
+	Name  string
+	Value string
+}
+
+func (e *PathParamError) Error() string {
+	return fmt.Sprintf("invalid path parameter %s=%q", e.Name, e.Value)
+}
+
+// upstreamTarget arma la URL del upstream con template. Cada {variable} se
+// valida contra su patrón (el de la ruta o PathParamPattern), no puede ser
+// "." ni ".." y se escapa como un único segmento, así un valor no puede
+// cambiar de ruta ni agregar query en el upstream.
+func (g *Gateway) upstreamTarget(upstream, template string, vars map[string]string, patterns map[string]*regexp.Regexp) (string, error) {
+	var path strings.Builder
+	rest := template
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			path.WriteString(rest)
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable in %q", template)
+		}
+		end += open
+		name := rest[open+1 : end]
+		value, ok := vars[name]
+		if !ok {
+			return "", fmt.Errorf("missing path parameter %q for %q", name, template)
+		}
+		pattern := patterns[name]
+		if pattern == nil {
+			pattern = g.pathParamPattern()
+		}
+		if value == "." || value == ".." || !pattern.MatchString(value) {
+			return "", &PathParamError{Name: name, Value: value}
+		}
+		path.WriteString(rest[:open])
+		path.WriteString(url.PathEscape(value))
+		rest = rest[end+1:]
+	}
+	return g.upstreamURL(upstream) + path.String(), nil
+}
+
+func (g *Gateway) pathParamPattern() *regexp.Regexp {
+	if g.config.PathParamPattern != nil {
+		return g.config.PathParamPattern
+	}
+	return defaultPathParamRegexp
+}
+
+// writeUpstreamTargetError responde 400 si el problema es un parámetro de la
+// petición y 500 si es la configuración de la ruta
+func writeUpstreamTargetError(w http.ResponseWriter, r *http.Request, err error) {
+	var paramErr *PathParamError
+	if errors.As(err, &paramErr) {
+		slog.WarnContext(r.Context(), "Rejected path parameter", "param", paramErr.Name, "value", paramErr.Value)
+		writeJSONError(w, http.StatusBadRequest, "Invalid path parameter: "+paramErr.Name)
+		return
+	}
+	slog.ErrorContext(r.Context(), "Could not build upstream URL", "error", err)
+	writeJSONError(w, http.StatusInternalServerError, "Could not build upstream URL")
+}
+
+// templateVars devuelve los nombres de las {variables} de una plantilla de
+// mux o de upstream ({nombre} o {nombre:patrón})
+func templateVars(template string) []string {
+	var names []string
+	for _, part := range strings.Split(template, "{")[1:] {
+		name, _, _ := strings.Cut(part, "}")
+		name, _, _ = strings.Cut(name, ":")
+		names = append(names, name)
+	}
+	return names
+}
+
+// ============================================
+// HANDLER - PROXY GENÉRICO DE LA TABLA
+// ============================================
+
+func (g *Gateway) handleRoute(route RouteConfig) http.HandlerFunc {
+	proxy := g.newRouteProxy(route)
+	return func(w http.ResponseWriter, r *http.Request) {
+		targetURL, err := g.upstreamTarget(route.Upstream, route.UpstreamPath, mux.Vars(r), route.ParamPatterns)
+		if err != nil {
+			writeUpstreamTargetError(w, r, err)
+			return
+		}
+		if r.URL.RawQuery != "" {
+			targetURL += "?" + r.URL.RawQuery
+		}
+		target, err := url.Parse(targetURL)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Invalid upstream URL", "route_name", route.Name, "upstream", route.Upstream, "url", targetURL, "error", err)
+			writeJSONError(w, http.StatusBadGateway, "Invalid upstream URL")
+			return
+		}
+
+		// El body va en streaming: solo se registra su tamaño
+		slog.DebugContext(r.Context(), "Proxying route",
+			"route_name", route.Name, "upstream", route.Upstream, "url", targetURL, "content_length", r.ContentLength)
+
+		ctx, cancel := context.WithTimeout(r.Context(), route.Timeout)
+		defer cancel()
+		ctx = withRetryPolicy(proxyTo(ctx, target), route.Retry)
+
+		proxy.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// registerRouteTable registra las rutas en el orden del archivo. El orden
+// importa: /profiles/me debe ir antes que /profiles/{username}.
+func (g *Gateway) registerRouteTable(router *mux.Router) {
+	if g.routeTable == nil {
+		return
+	}
+	for _, route := range g.routeTable.Routes {
+		handler := g.handleRoute(route)
+		if route.Method == "POST" || route.Method == "PATCH" {
+			handler = g.idempotent(handler)
+		}
+		if route.LoginLockout {
+			handler = g.loginLockout(handler)
+		}
+		if route.AuthRequired {
+			handler = g.requireAuth(handler)
+		}
+		router.HandleFunc(route.Path, handler).Methods(route.Method)
+	}
+}
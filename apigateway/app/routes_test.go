@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadRouteTableDefaultFile(t *testing.T) {
+	table, err := LoadRouteTable("../config/routes.yaml")
+	if err != nil {
+		t.Fatalf("LoadRouteTable() error = %v", err)
+	}
+	if len(table.Routes) == 0 {
+		t.Fatal("expected routes in default route table")
+	}
+	for _, route := range table.Routes {
+		if route.Timeout <= 0 {
+			t.Errorf("route %s has no timeout", route.Name)
+		}
+	}
+}
+
+func TestParseRouteTable(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		table, err := parseRouteTable([]byte(`{"routes":[{"name":"x","method":"get","path":"/a/{id}","upstream":"profiles","upstream_path":"/b/{id}","auth":true,"timeout":"2s"}]}`))
+		if err != nil {
+			t.Fatalf("parseRouteTable() error = %v", err)
+		}
+		route := table.Routes[0]
+		if route.Method != "GET" || !route.AuthRequired || route.Timeout != 2*time.Second {
+			t.Fatalf("unexpected route %+v", route)
+		}
+	})
+
+	invalid := map[string]string{
+		"unknown upstream": "routes:\n  - {name: x, method: GET, path: /a, upstream: billing, upstream_path: /b}\n",
+		"bad method":       "routes:\n  - {name: x, method: TRACE, path: /a, upstream: auth, upstream_path: /b}\n",
+		"bad timeout":      "routes:\n  - {name: x, method: GET, path: /a, upstream: auth, upstream_path: /b, timeout: soon}\n",
+		"unknown variable": "routes:\n  - {name: x, method: GET, path: /a/{id}, upstream: auth, upstream_path: /b/{user}}\n",
+		"bad param regex":  "routes:\n  - {name: x, method: GET, path: /a/{id}, upstream: auth, upstream_path: /b/{id}, params: {id: '[a-'}}\n",
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseRouteTable([]byte(data)); err == nil {
+				t.Fatal("expected validation error")
+			}
+		})
+	}
+}
+
+func TestRouteTableProxying(t *testing.T) {
+	var gotPath, gotQuery, gotBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotQuery = r.URL.RawQuery
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	table, err := parseRouteTable([]byte(`
+routes:
+  - name: register
+    method: POST
+    path: /api/v1/auth/register
+    upstream: auth
+    upstream_path: /accounts
+  - name: public-profile
+    method: GET
+    path: /api/v1/profiles/{username}
+    upstream: profiles
+    upstream_path: /profiles/{username}
+  - name: stats
+    method: GET
+    path: /api/v1/stats
+    upstream: profiles
+    upstream_path: /profiles/stats/me
+    auth: true
+`))
+	if err != nil {
+		t.Fatalf("parseRouteTable() error = %v", err)
+	}
+
+	g := NewGateway(&Config{JWTSecret: testSecret, AuthServiceURL: upstream.URL, ProfileServiceURL: upstream.URL})
+	g.routeTable = table
+	router := g.setupRoutes()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/auth/register", strings.NewReader(`{"username":"bob"}`)))
+	if rec.Code != http.StatusCreated || gotPath != "/accounts" || gotBody != `{"username":"bob"}` {
+		t.Fatalf("register: status=%d path=%s body=%s", rec.Code, gotPath, gotBody)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/profiles/bob?fields=bio", nil))
+	if rec.Code != http.StatusCreated || gotPath != "/profiles/bob" || gotQuery != "fields=bio" {
+		t.Fatalf("public profile: status=%d path=%s query=%s", rec.Code, gotPath, gotQuery)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/stats", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("stats without token: status=%d, want 401", rec.Code)
+	}
+}
+
+func TestUpstreamTargetEscapesAndValidatesParams(t *testing.T) {
+	g := NewGateway(&Config{AuthServiceURL: "http://auth:3500"})
+
+	got, err := g.upstreamTarget("auth", "/accounts/{username}", map[string]string{"username": "ana.perez+qa@example.com"}, nil)
+	if err != nil || got != "http://auth:3500/accounts/ana.perez+qa@example.com" {
+		t.Fatalf("upstreamTarget() = %q, %v", got, err)
+	}
+
+	// Con un patrón más permisivo el valor igual queda en un solo segmento
+	loose, _ := compilePathParamPattern(`.+`)
+	got, err = g.upstreamTarget("auth", "/accounts/{username}", map[string]string{"username": "a/b?c=d#e"}, map[string]*regexp.Regexp{"username": loose})
+	if err != nil || got != "http://auth:3500/accounts/a%2Fb%3Fc=d%23e" {
+		t.Fatalf("upstreamTarget() = %q, %v", got, err)
+	}
+
+	for _, value := range []string{"", ".", "..", "../admin", "bob?admin=true", "bob/../admin", "bob%2F", strings.Repeat("a", 129)} {
+		_, err := g.upstreamTarget("auth", "/accounts/{username}", map[string]string{"username": value}, nil)
+		var paramErr *PathParamError
+		if !errors.As(err, &paramErr) || paramErr.Name != "username" {
+			t.Errorf("upstreamTarget(%q) error = %v, want PathParamError", value, err)
+		}
+	}
+	if _, err := g.upstreamTarget("auth", "/accounts/{id}", map[string]string{}, nil); err == nil {
+		t.Error("expected error for missing parameter")
+	}
+	if _, err := g.upstreamTarget("auth", "/accounts/{username}", map[string]string{"username": "."}, map[string]*regexp.Regexp{"username": loose}); err == nil {
+		t.Error("dot segments must be rejected whatever the pattern")
+	}
+}
+
+func TestHandlersRejectUnsafePathParams(t *testing.T) {
+	var calls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"user":{"username":"bob"}}`))
+	}))
+	defer upstream.Close()
+
+	table, err := parseRouteTable([]byte(`
+routes:
+  - name: public-profile
+    method: GET
+    path: /api/v1/profiles/{username}
+    upstream: profiles
+    upstream_path: /profiles/{username}
+    params: { username: '[a-z]{3,10}' }
+`))
+	if err != nil {
+		t.Fatalf("parseRouteTable() error = %v", err)
+	}
+	g := NewGateway(&Config{JWTSecret: testSecret, AuthServiceURL: upstream.URL, ProfileServiceURL: upstream.URL})
+	g.routeTable = table
+	compositions, err := LoadCompositions("../config/compositions.yaml")
+	if err != nil {
+		t.Fatalf("LoadCompositions() error = %v", err)
+	}
+	g.compositions = compositions
+	router := g.setupRoutes()
+	token := signTestToken(t, testSecret, map[string]interface{}{"sub": "bob", "role": "admin", "exp": time.Now().Add(time.Hour).Unix()})
+
+	for _, tc := range []struct{ method, path string }{
+		{"GET", "/api/v1/profiles/bob%3Fadmin=1"},
+		{"GET", "/api/v1/profiles/BOB"}, // patrón propio de la ruta
+		{"GET", "/api/v1/users/bob%3Fx=1/profile"},
+		{"DELETE", "/api/v1/users/bob%23frag"},
+		{"PATCH", "/api/v1/users/bob%20x/profile"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{"bio":"x"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: status %d, want 400", tc.method, tc.path, rec.Code)
+		}
+	}
+	if calls != 0 {
+		t.Fatalf("upstream received %d calls for rejected parameters", calls)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// ============================================
+// ACTUALIZACIÓN UNIFICADA - ESCRITURAS Y COMPENSACIÓN
+// ============================================
+
+// userSnapshot guarda el valor previo de los campos que se van a modificar.
+// Los campos que no existían antes de la petición van aparte: no hay un valor
+// que restaurar y mandar null sería escribir algo que el servicio nunca tuvo.
+type userSnapshot struct {
+	auth         map[string]interface{}
+	profile      map[string]interface{}
+	authUnset    []string
+	profileUnset []string
+}
+
+// writeAuthFields aplica un PATCH parcial sobre la cuenta en auth
+func (g *Gateway) writeAuthFields(r *http.Request, username, authHeader string, fields map[string]interface{}) *ServiceResponse {
+	body, _ := json.Marshal(fields)
+	targetURL, err := g.accountURL(username)
+	if err != nil {
+		return &ServiceResponse{Error: err}
+	}
+	return g.upstreamJSONRequest(r, "auth", "PATCH", targetURL, authHeader, body)
+}
+
+// writeProfileFields aplica un PUT sobre el perfil (campos en snake_case)
+func (g *Gateway) writeProfileFields(r *http.Request, authHeader string, fields map[string]interface{}) *ServiceResponse {
+	body, _ := json.Marshal(fields)
+	targetURL := g.upstreamURL("profiles") + "/profiles/me"
+	return g.upstreamJSONRequest(r, "profiles", "PUT", targetURL, authHeader, body)
+}
+
+// accountURL es la URL de la cuenta de username en auth
+func (g *Gateway) accountURL(username string) (string, error) {
+	return g.upstreamTarget("auth", "/accounts/{username}", map[string]string{"username": username}, nil)
+}
+
+func (g *Gateway) upstreamJSONRequest(r *http.Request, upstream, method, targetURL, authHeader string, body []byte) *ServiceResponse {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Authorization", authHeader)
+	return g.bufferedRequest(upstream, r, method, targetURL, header, body)
+}
+
+// snapshotUserFields lee en paralelo el estado actual de los campos que se van
+// a escribir en auth y en profiles
+func (g *Gateway) snapshotUserFields(r *http.Request, username, authHeader string, authFields, profileFields map[string]interface{}) (*userSnapshot, error) {
+	var (
+		wg                    sync.WaitGroup
+		authData, profileData map[string]interface{}
+		authErr, profileErr   error
+	)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		targetURL, err := g.accountURL(username)
+		if err != nil {
+			authErr = err
+			return
+		}
+		authData, authErr = g.fetchJSONObject(r, "auth", targetURL, authHeader, "user")
+	}()
+	go func() {
+		defer wg.Done()
+		targetURL := g.upstreamURL("profiles") + "/profiles/me"
+		profileData, profileErr = g.fetchJSONObject(r, "profiles", targetURL, authHeader, "profile")
+	}()
+	wg.Wait()
+
+	if authErr != nil {
+		return nil, fmt.Errorf("auth: %w", authErr)
+	}
+	if profileErr != nil {
+		return nil, fmt.Errorf("profiles: %w", profileErr)
+	}
+
+	snapshot := &userSnapshot{}
+	snapshot.auth, snapshot.authUnset = pickFields(authData, authFields)
+	snapshot.profile, snapshot.profileUnset = pickFields(profileData, profileFields)
+	return snapshot, nil
+}
+
+// fetchJSONObject hace un GET y devuelve el objeto JSON; si la respuesta lo
+// envuelve en wrapperKey ({"user": {...}}) devuelve el objeto interior
+func (g *Gateway) fetchJSONObject(r *http.Request, upstream, targetURL, authHeader, wrapperKey string) (map[string]interface{}, error) {
+	resp := g.upstreamJSONRequest(r, upstream, "GET", targetURL, authHeader, nil)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("returned status %d", resp.StatusCode)
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(resp.Body, &data); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if inner, ok := data[wrapperKey].(map[string]interface{}); ok {
+		return inner, nil
+	}
+	return data, nil
+}
+
+// pickFields toma de current el valor de cada campo de fields que exista
+// (aunque sea null) y devuelve aparte, ordenados, los que no existen
+func pickFields(current, fields map[string]interface{}) (map[string]interface{}, []string) {
+	previous := make(map[string]interface{}, len(fields))
+	var unset []string
+	for field := range fields {
+		value, ok := current[field]
+		if !ok {
+			unset = append(unset, field)
+			continue
+		}
+		previous[field] = value
+	}
+	sort.Strings(unset)
+	return previous, unset
+}
+
+// compensateUserUpdate restaura los valores previos en los servicios que sí
+// aplicaron el cambio. Devuelve qué servicios se restauraron, cuáles no y los
+// campos (servicio.campo) que no existían antes y quedan con el valor nuevo.
+func (g *Gateway) compensateUserUpdate(r *http.Request, username, authHeader string, snapshot *userSnapshot, succeeded []string) ([]string, []string, []string) {
+	rolledBack := []string{}
+	rollbackFailed := []string{}
+	notRestored := []string{}
+
+	// La compensación debe terminar aunque el cliente ya se haya ido o se haya
+	// vencido el deadline de la petición, pero con un límite propio
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), defaultRouteTimeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	for _, service := range succeeded {
+		var previous map[string]interface{}
+		var unset []string
+		var write func(map[string]interface{}) *ServiceResponse
+		switch service {
+		case "auth":
+			previous, unset = snapshot.auth, snapshot.authUnset
+			write = func(fields map[string]interface{}) *ServiceResponse {
+				return g.writeAuthFields(r, username, authHeader, fields)
+			}
+		case "profile":
+			previous, unset = snapshot.profile, snapshot.profileUnset
+			write = func(fields map[string]interface{}) *ServiceResponse {
+				return g.writeProfileFields(r, authHeader, fields)
+			}
+		default:
+			continue
+		}
+
+		for _, field := range unset {
+			notRestored = append(notRestored, service+"."+field)
+		}
+		if len(unset) > 0 {
+			slog.WarnContext(r.Context(), "Compensation cannot unset new fields", "upstream", service, "username", username, "fields", unset)
+		}
+		if len(previous) == 0 {
+			continue
+		}
+
+		resp := write(previous)
+
+		if resp.Error != nil || resp.StatusCode != http.StatusOK {
+			slog.ErrorContext(r.Context(), "Compensation failed", "upstream", service, "username", username, "status", resp.StatusCode, "error", resp.Error)
+			rollbackFailed = append(rollbackFailed, service)
+			continue
+		}
+		slog.InfoContext(r.Context(), "Compensated update", "upstream", service, "username", username)
+		rolledBack = append(rolledBack, service)
+	}
+	return rolledBack, rollbackFailed, notRestored
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUserServices simula auth y profiles guardando el estado en memoria
+type fakeUserServices struct {
+	mu          sync.Mutex
+	account     map[string]interface{}
+	profile     map[string]interface{}
+	failProfile bool
+}
+
+func (f *fakeUserServices) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var patch map[string]interface{}
+	if r.Body != nil {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &patch)
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/accounts/") && r.Method == "GET":
+		json.NewEncoder(w).Encode(map[string]interface{}{"user": f.account})
+	case strings.HasPrefix(r.URL.Path, "/accounts/") && r.Method == "PATCH":
+		for k, v := range patch {
+			f.account[k] = v
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"user": f.account})
+	case r.URL.Path == "/profiles/me" && r.Method == "GET":
+		json.NewEncoder(w).Encode(f.profile)
+	case r.URL.Path == "/profiles/me" && r.Method == "PUT":
+		if f.failProfile {
+			http.Error(w, `{"error":"boom"}`, http.StatusInternalServerError)
+			return
+		}
+		for k, v := range patch {
+			f.profile[k] = v
+		}
+		json.NewEncoder(w).Encode(f.profile)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestUnifiedUpdateRollsBackOnPartialFailure(t *testing.T) {
+	services := &fakeUserServices{
+		account:     map[string]interface{}{"username": "bob", "firstName": "Bob", "phone": "+34111"},
+		profile:     map[string]interface{}{"bio": "old bio", "github_url": nil},
+		failProfile: true,
+	}
+	upstream := httptest.NewServer(services)
+	defer upstream.Close()
+
+	g := NewGateway(&Config{
+		JWTSecret:         testSecret,
+		AuthServiceURL:    upstream.URL,
+		ProfileServiceURL: upstream.URL,
+		UnifiedUpdateSaga: true,
+	})
+	router := g.setupRoutes()
+
+	token := signTestToken(t, testSecret, map[string]interface{}{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+	req := httptest.NewRequest("PATCH", "/api/v1/users/bob/profile", strings.NewReader(`{"firstName":"Robert","bio":"new bio"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	var body struct {
+		Error      string   `json:"error"`
+		Failed     []string `json:"failed"`
+		RolledBack []string `json:"rolledBack"`
+		Consistent bool     `json:"consistent"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid body %s: %v", rec.Body.String(), err)
+	}
+	if body.Error == "" || len(body.Failed) != 1 || body.Failed[0] != "profile" {
+		t.Errorf("unexpected failure report %+v", body)
+	}
+	if len(body.RolledBack) != 1 || body.RolledBack[0] != "auth" || !body.Consistent {
+		t.Errorf("auth should have been rolled back: %+v", body)
+	}
+
+	services.mu.Lock()
+	defer services.mu.Unlock()
+	if services.account["firstName"] != "Bob" {
+		t.Errorf("firstName = %v, want original value restored", services.account["firstName"])
+	}
+}
+
+func TestUnifiedUpdateLeavesNewFieldsOnRollback(t *testing.T) {
+	services := &fakeUserServices{
+		account:     map[string]interface{}{"username": "bob", "firstName": "Bob", "lastName": nil},
+		profile:     map[string]interface{}{"bio": "old bio"},
+		failProfile: true,
+	}
+	var mu sync.Mutex
+	var compensation map[string]interface{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PATCH" {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			compensation = nil
+			json.Unmarshal(body, &compensation)
+			mu.Unlock()
+			r.Body = io.NopCloser(strings.NewReader(string(body)))
+		}
+		services.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+
+	g := NewGateway(&Config{
+		JWTSecret:         testSecret,
+		AuthServiceURL:    upstream.URL,
+		ProfileServiceURL: upstream.URL,
+		UnifiedUpdateSaga: true,
+	})
+	router := g.setupRoutes()
+
+	token := signTestToken(t, testSecret, map[string]interface{}{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+	req := httptest.NewRequest("PATCH", "/api/v1/users/bob/profile", strings.NewReader(`{"firstName":"Robert","lastName":"Smith","phone":"+34999","bio":"new bio"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var body struct {
+		RolledBack  []string `json:"rolledBack"`
+		NotRestored []string `json:"notRestored"`
+		Consistent  bool     `json:"consistent"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid body %s: %v", rec.Body.String(), err)
+	}
+	if len(body.RolledBack) != 1 || len(body.NotRestored) != 1 || body.NotRestored[0] != "auth.phone" || body.Consistent {
+		t.Fatalf("outcome = %+v, want auth rolled back except phone and consistent false", body)
+	}
+
+	// Los campos que existían se restauran, también el null de lastName; phone
+	// no existía y no se manda
+	mu.Lock()
+	defer mu.Unlock()
+	if _, sent := compensation["phone"]; sent {
+		t.Errorf("compensation sent phone: %v", compensation)
+	}
+	if value, sent := compensation["lastName"]; !sent || value != nil {
+		t.Errorf("compensation lastName = %v (sent %v), want explicit null", value, sent)
+	}
+	services.mu.Lock()
+	defer services.mu.Unlock()
+	if services.account["firstName"] != "Bob" || services.account["phone"] != "+34999" {
+		t.Errorf("account = %v, want firstName restored and phone kept", services.account)
+	}
+}
+
+func TestUnifiedUpdateByAdminOnOtherUser(t *testing.T) {
+	services := &fakeUserServices{
+		account: map[string]interface{}{"username": "bob", "firstName": "Bob"},
+		profile: map[string]interface{}{"bio": "admin bio"},
+	}
+	upstream := httptest.NewServer(services)
+	defer upstream.Close()
+
+	g := NewGateway(&Config{
+		JWTSecret:         testSecret,
+		AuthServiceURL:    upstream.URL,
+		ProfileServiceURL: upstream.URL,
+		UnifiedUpdateSaga: true,
+	})
+	compositions, err := LoadCompositions("../config/compositions.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.compositions = compositions
+	router := g.setupRoutes()
+	token := signTestToken(t, testSecret, map[string]interface{}{"sub": "root", "role": "admin", "exp": time.Now().Add(time.Hour).Unix()})
+	update := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/api/v1/users/bob/profile", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// /profiles/me con el token del admin es el perfil del admin: se rechaza
+	// sin escribir nada
+	if rec := update(`{"firstName":"Robert","bio":"new bio"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("profile fields by admin: status = %d, want 403", rec.Code)
+	}
+	services.mu.Lock()
+	if services.account["firstName"] != "Bob" || services.profile["bio"] != "admin bio" {
+		t.Fatalf("rejected update was applied: account %v, profile %v", services.account, services.profile)
+	}
+	services.mu.Unlock()
+
+	// Los campos de la cuenta sí se pueden modificar
+	if rec := update(`{"firstName":"Robert"}`); rec.Code != http.StatusOK {
+		t.Fatalf("account fields by admin: status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	services.mu.Lock()
+	defer services.mu.Unlock()
+	if services.account["firstName"] != "Robert" || services.profile["bio"] != "admin bio" {
+		t.Errorf("account %v, profile %v", services.account, services.profile)
+	}
+}
+
+func TestUnifiedUpdateReadsBackWithGet(t *testing.T) {
+	services := &fakeUserServices{
+		account: map[string]interface{}{"username": "bob", "firstName": "Bob"},
+		profile: map[string]interface{}{"bio": "old bio"},
+	}
+	var mu sync.Mutex
+	var calls []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path+" "+r.Header.Get("Content-Type"))
+		mu.Unlock()
+		services.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+
+	g := NewGateway(&Config{
+		JWTSecret:         testSecret,
+		AuthServiceURL:    upstream.URL,
+		ProfileServiceURL: upstream.URL,
+		UnifiedUpdateSaga: true,
+	})
+	compositions, err := LoadCompositions("../config/compositions.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.compositions = compositions
+	router := g.setupRoutes()
+
+	token := signTestToken(t, testSecret, map[string]interface{}{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+	req := httptest.NewRequest("PATCH", "/api/v1/users/bob/profile", strings.NewReader(`{"firstName":"Robert"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	// La única escritura es el PATCH de la cuenta: la respuesta se arma con
+	// lecturas, GET y sin el Content-Type del body original
+	mu.Lock()
+	defer mu.Unlock()
+	writes := 0
+	for _, call := range calls {
+		if strings.HasPrefix(call, "GET ") {
+			if !strings.HasSuffix(call, " ") {
+				t.Errorf("read %q kept the request Content-Type", call)
+			}
+			continue
+		}
+		writes++
+		if !strings.HasPrefix(call, "PATCH /accounts/bob ") {
+			t.Errorf("unexpected upstream write %q", call)
+		}
+	}
+	if writes != 1 {
+		t.Errorf("upstream calls %v, want exactly one write", calls)
+	}
+}
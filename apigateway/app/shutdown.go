@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ============================================
+// APAGADO ORDENADO
+// ============================================
+
+// ShutdownSettings controla el apagado al recibir SIGTERM
+type ShutdownSettings struct {
+	// Delay es cuánto se sigue atendiendo con /health fallando, para que
+	// Consul y los balanceadores dejen de mandar tráfico
+	Delay time.Duration
+	// Timeout es el máximo para terminar las peticiones en curso y publicar
+	// los eventos pendientes del outbox
+	Timeout time.Duration
+}
+
+// shutdown apaga el gateway en orden: readiness en falla, deja de aceptar
+// conexiones, espera las peticiones en curso y los eventos pendientes hasta
+// el plazo, y por último se da de baja en Consul. stopBackground cancela el
+// contexto de los loops de fondo (watchers de Consul y dispatcher del outbox).
+func (g *Gateway) shutdown(server *http.Server, settings ShutdownSettings, stopBackground context.CancelFunc, deregister func()) {
+	g.draining.Store(true)
+	slog.Info("Shutting down, readiness is now failing", "delay", settings.Delay.String(), "timeout", settings.Timeout.String())
+	time.Sleep(settings.Delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), settings.Timeout)
+	defer cancel()
+
+	// Shutdown cierra los listeners y espera a que terminen los handlers
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("In-flight requests did not finish before the deadline, closing connections", "error", err)
+		server.Close()
+	} else {
+		slog.Info("In-flight requests finished")
+	}
+
+	// Sin peticiones ya no hacen falta los watchers; el outbox se vacía aparte
+	stopBackground()
+
+	if g.outbox != nil {
+		pending, err := g.outbox.Drain(ctx)
+		if err != nil {
+			slog.Warn("Outbox not drained before the deadline; events stay stored for the next start", "pending", pending, "error", err)
+		} else if pending > 0 {
+			slog.Warn("Some events could not be published; they stay stored for the next start", "pending", pending)
+		} else {
+			slog.Info("Outbox drained")
+		}
+	}
+
+	deregister()
+}
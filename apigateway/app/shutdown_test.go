@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingPublisher no termina hasta que se cancela el contexto
+type blockingPublisher struct{}
+
+func (blockingPublisher) Publish(ctx context.Context, event *DomainEvent) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestShutdownDrainsRequestsAndOutbox(t *testing.T) {
+	store := openTestOutbox(t)
+	publisher := &flakyPublisher{}
+	g := NewGateway(&Config{JWTSecret: testSecret})
+	g.outbox = NewOutboxDispatcher(store, publisher, time.Hour, 3)
+	background, stopBackground := context.WithCancel(context.Background())
+	g.outbox.Start(background)
+
+	// Sin notify: el evento solo se publica si el apagado vacía el outbox
+	if _, err := store.Add(&DomainEvent{ID: "evt-1", Type: "user.deleted"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	router := g.setupRoutes()
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			time.Sleep(300 * time.Millisecond)
+			io.WriteString(w, "done")
+			return
+		}
+		router.ServeHTTP(w, r)
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go server.Serve(ln)
+	base := "http://" + ln.Addr().String()
+
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(base + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		slow <- string(body)
+	}()
+	<-started
+
+	var publishedAtDeregister atomic.Int32
+	var stoppedAtDeregister atomic.Bool
+	deregistered := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		g.shutdown(server, ShutdownSettings{Delay: 200 * time.Millisecond, Timeout: 2 * time.Second}, stopBackground, func() {
+			_, published := publisher.snapshot()
+			publishedAtDeregister.Store(int32(len(published)))
+			stoppedAtDeregister.Store(background.Err() != nil)
+			close(deregistered)
+		})
+		close(done)
+	}()
+
+	// Durante el Delay se sigue atendiendo, pero /health ya falla
+	for !g.draining.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	resp, err := http.Get(base + "/health")
+	if err != nil {
+		t.Fatalf("health during delay: %v", err)
+	}
+	var health map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&health)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || health["status"] != "SHUTTING_DOWN" {
+		t.Fatalf("health = %d %v, want 503 SHUTTING_DOWN", resp.StatusCode, health)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not finish")
+	}
+	if body := <-slow; body != "done" {
+		t.Fatalf("in-flight request = %q, want it to complete", body)
+	}
+	if _, err := http.Get(base + "/health"); err == nil {
+		t.Fatal("new connections must be refused after shutdown")
+	}
+	<-deregistered
+	if publishedAtDeregister.Load() != 1 {
+		t.Fatalf("deregister ran with %d events published, want the outbox drained first", publishedAtDeregister.Load())
+	}
+	if !stoppedAtDeregister.Load() {
+		t.Fatal("deregister ran with the background loops (Consul watchers) still running")
+	}
+	if pending, _ := store.List(""); len(pending) != 0 {
+		t.Fatalf("outbox not drained: %+v", pending)
+	}
+}
+
+func TestOutboxDrainRespectsDeadline(t *testing.T) {
+	store := openTestOutbox(t)
+	dispatcher := NewOutboxDispatcher(store, blockingPublisher{}, time.Hour, 3)
+	if _, err := store.Add(&DomainEvent{ID: "evt-1", Type: "user.deleted"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	pending, err := dispatcher.Drain(ctx)
+	if err == nil || pending != 1 {
+		t.Fatalf("Drain() = %d, %v; want the event left pending and a deadline error", pending, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Drain() took %v, want it bounded by the deadline", elapsed)
+	}
+}
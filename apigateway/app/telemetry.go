@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ============================================
+// TRAZAS - OPENTELEMETRY
+// ============================================
+
+const tracerName = "github.com/ProyectoFinal-Microservicios/architecture/apigateway"
+
+// TracingConfig configura la exportación de trazas. Sin Endpoint los spans
+// se crean igual (los ids se propagan) pero no se exportan.
+type TracingConfig struct {
+	Endpoint    string  // OTLP/HTTP, p.ej. http://otel-collector:4318
+	ServiceName string  // service.name de los spans
+	SampleRatio float64 // fracción de trazas nuevas que se muestrean
+}
+
+// NewTracerProvider crea el provider con el exportador OTLP/HTTP. Las trazas
+// que ya vienen muestreadas por el cliente se respetan (ParentBased).
+func NewTracerProvider(ctx context.Context, config TracingConfig) (*sdktrace.TracerProvider, error) {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(config.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	}
+	if config.Endpoint != "" {
+		endpoint, err := otlpTracesURL(config.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	return sdktrace.NewTracerProvider(options...), nil
+}
+
+// otlpTracesURL agrega /v1/traces si el endpoint es solo la base del collector
+func otlpTracesURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid OTLP endpoint %q", endpoint)
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		u.Path = "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// tracer devuelve el tracer del gateway
+func (g *Gateway) tracer() trace.Tracer {
+	return g.tracerProvider.Tracer(tracerName)
+}
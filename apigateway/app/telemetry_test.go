@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// useTestTracer hace que el gateway exporte sus spans a memoria
+func useTestTracer(t *testing.T, g *Gateway) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	g.tracerProvider = provider
+	if g.outbox != nil {
+		g.outbox.tracer = provider.Tracer(tracerName)
+	}
+	return exporter
+}
+
+func spanAttr(span tracetest.SpanStub, key attribute.Key) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestOTLPTracesURL(t *testing.T) {
+	cases := map[string]string{
+		"http://otel-collector:4318":            "http://otel-collector:4318/v1/traces",
+		"http://otel-collector:4318/":           "http://otel-collector:4318/v1/traces",
+		"https://collector.example.com/otlp/v1": "https://collector.example.com/otlp/v1",
+	}
+	for endpoint, want := range cases {
+		if got, err := otlpTracesURL(endpoint); err != nil || got != want {
+			t.Errorf("otlpTracesURL(%q) = %q, %v; want %q", endpoint, got, err, want)
+		}
+	}
+	if _, err := otlpTracesURL("otel-collector:4318"); err == nil {
+		t.Error("expected error for endpoint without scheme")
+	}
+}
+
+func TestUnifiedGetSpanTree(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/accounts/bob":
+			json.NewEncoder(w).Encode(map[string]interface{}{"user": map[string]interface{}{"username": "bob"}})
+		default:
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+
+	compositions, err := LoadCompositions("../config/compositions.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGateway(&Config{JWTSecret: testSecret, AuthServiceURL: upstream.URL, ProfileServiceURL: upstream.URL})
+	g.compositions = compositions
+	exporter := useTestTracer(t, g)
+	router := g.setupRoutes()
+	handler := g.tracingMiddleware(router, router)
+
+	token := signTestToken(t, testSecret, map[string]interface{}{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+	req := httptest.NewRequest("GET", "/api/v1/users/bob/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	var server tracetest.SpanStub
+	clients := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		switch span.SpanKind {
+		case trace.SpanKindServer:
+			server = span
+		case trace.SpanKindClient:
+			clients[spanAttr(span, upstreamAttr)] = span
+		}
+	}
+
+	if server.Name != "GET /api/v1/users/{username}/profile" {
+		t.Fatalf("server span name = %q", server.Name)
+	}
+	if spanAttr(server, usernameAttr) != "bob" || spanAttr(server, "http.response.status_code") != "200" {
+		t.Errorf("server span attributes = %v", server.Attributes)
+	}
+	if len(clients) != 2 {
+		t.Fatalf("client spans = %v, want auth and profiles", clients)
+	}
+	for name, span := range clients {
+		if span.Parent.SpanID() != server.SpanContext.SpanID() || span.SpanContext.TraceID() != server.SpanContext.TraceID() {
+			t.Errorf("%s span is not a child of the server span", name)
+		}
+		if spanAttr(span, usernameAttr) != "bob" {
+			t.Errorf("%s span has no username: %v", name, span.Attributes)
+		}
+	}
+	if clients["auth"].Status.Code == codes.Error || spanAttr(clients["auth"], "http.response.status_code") != "200" {
+		t.Errorf("auth span = %+v", clients["auth"].Status)
+	}
+	if clients["profiles"].Status.Code != codes.Error || spanAttr(clients["profiles"], "http.response.status_code") != "503" {
+		t.Errorf("profiles span = %+v", clients["profiles"].Status)
+	}
+}
+
+func TestOutboxPublishSpanContinuesRequestTrace(t *testing.T) {
+	publisher := &flakyPublisher{failures: 1}
+	g := NewGateway(&Config{UserDeletedRoutingKey: "user.deleted"})
+	g.outbox = NewOutboxDispatcher(openTestOutbox(t), publisher, time.Hour, 3)
+	exporter := useTestTracer(t, g)
+
+	ctx, requestSpan := g.tracer().Start(context.Background(), "DELETE /api/v1/users/{username}")
+	ctx = withTraceContext(ctx, TraceContext{RequestID: "req-1"})
+	g.publishUserDeletedEvent(ctx, "bob")
+	requestSpan.End()
+
+	g.outbox.dispatchDue(context.Background())
+	records, _ := g.outbox.store.List("")
+	if len(records) != 1 {
+		t.Fatalf("outbox = %v", records)
+	}
+	g.outbox.dispatch(context.Background(), records[0])
+
+	var publishes []tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.SpanKind == trace.SpanKindProducer {
+			publishes = append(publishes, span)
+		}
+	}
+	if len(publishes) != 2 {
+		t.Fatalf("producer spans = %d, want 2 (failed + retried)", len(publishes))
+	}
+	for _, span := range publishes {
+		if span.Name != "user.deleted publish" || span.Parent.SpanID() != requestSpan.SpanContext().SpanID() ||
+			span.SpanContext.TraceID() != requestSpan.SpanContext().TraceID() {
+			t.Errorf("publish span %q is not a child of the request span", span.Name)
+		}
+		if spanAttr(span, "messaging.rabbitmq.destination.routing_key") != "user.deleted" {
+			t.Errorf("publish span attributes = %v", span.Attributes)
+		}
+	}
+	if publishes[0].Status.Code != codes.Error || publishes[1].Status.Code == codes.Error {
+		t.Errorf("publish span statuses = %v, %v", publishes[0].Status, publishes[1].Status)
+	}
+	if _, published := publisher.snapshot(); len(published) != 1 {
+		t.Errorf("published = %d events, want 1", len(published))
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ============================================
+// TRAZAS - REQUEST ID Y TRACEPARENT
+// ============================================
+
+const (
+	requestIDHeader   = "X-Request-ID"
+	traceparentHeader = "traceparent"
+	maxRequestIDLen   = 128
+)
+
+// traceContextPropagator lee y escribe la cabecera traceparent (W3C)
+var traceContextPropagator = propagation.TraceContext{}
+
+// TraceContext identifica una petición a través de los servicios: el
+// X-Request-ID y el span de servidor del gateway
+type TraceContext struct {
+	RequestID string
+	TraceID   string // 32 hex
+	SpanID    string // 16 hex, span de servidor del gateway
+	ParentID  string // span que nos llamó (vacío si empezamos la traza)
+	Flags     string // 2 hex
+}
+
+// Traceparent devuelve la cabecera W3C con el span del gateway
+func (tc TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%s", tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+// newTraceContext arma el contexto de traza a partir del span del gateway y
+// del span remoto que lo originó (si el cliente envió traceparent)
+func newTraceContext(requestID string, span, parent trace.SpanContext) TraceContext {
+	tc := TraceContext{
+		RequestID: requestID,
+		TraceID:   span.TraceID().String(),
+		SpanID:    span.SpanID().String(),
+		Flags:     span.TraceFlags().String(),
+	}
+	if parent.IsValid() {
+		tc.ParentID = parent.SpanID().String()
+	}
+	return tc
+}
+
+// validRequestID acepta ids de hasta 128 caracteres sin espacios ni
+// caracteres de control, para que no rompan logs ni cabeceras
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDFor toma el X-Request-ID de la petición si es válido y si no
+// genera uno
+func requestIDFor(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); validRequestID(id) {
+		return id
+	}
+	return randomHex(16)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type traceContextKey struct{}
+
+func withTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// traceFromContext devuelve el contexto de traza de la petición, si lo hay
+func traceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// setTraceHeaders copia el request id y el span actual (el span de cliente de
+// la llamada, si lo hay) a una petición saliente
+func setTraceHeaders(ctx context.Context, header http.Header) {
+	if tc, ok := traceFromContext(ctx); ok {
+		header.Set(requestIDHeader, tc.RequestID)
+	}
+	traceContextPropagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// traceMeta agrega los ids de traza a los metadatos de un evento
+func traceMeta(ctx context.Context, meta map[string]interface{}) map[string]interface{} {
+	if tc, ok := traceFromContext(ctx); ok {
+		meta["requestId"] = tc.RequestID
+	}
+	carrier := propagation.MapCarrier{}
+	traceContextPropagator.Inject(ctx, carrier)
+	if traceparent := carrier.Get(traceparentHeader); traceparent != "" {
+		meta["traceparent"] = traceparent
+	}
+	return meta
+}
+
+// eventTraceContext recupera el span de la petición que generó el evento,
+// para que la publicación continúe esa traza
+func eventTraceContext(ctx context.Context, event *DomainEvent) context.Context {
+	traceparent, _ := event.Meta["traceparent"].(string)
+	if traceparent == "" {
+		return ctx
+	}
+	return traceContextPropagator.Extract(ctx, propagation.MapCarrier{traceparentHeader: traceparent})
+}
+
+// ============================================
+// MIDDLEWARE - TRAZAS
+// ============================================
+
+// Atributos propios del gateway en los spans
+const (
+	usernameAttr  = attribute.Key("gateway.username")
+	upstreamAttr  = attribute.Key("gateway.upstream")
+	requestIDAttr = attribute.Key("gateway.request_id")
+)
+
+// tracingMiddleware abre el span de servidor (nombrado con la plantilla de la
+// ruta), asigna el request id y los devuelve en la respuesta. Va antes que el
+// resto de middlewares para que todos sus logs lo incluyan.
+func (g *Gateway) tracingMiddleware(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := requestIDFor(r)
+		ctx := traceContextPropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		parent := trace.SpanContextFromContext(ctx)
+
+		template, vars := matchRoute(router, r)
+		ctx, span := g.tracer().Start(ctx, r.Method+" "+template,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(template),
+				semconv.URLPath(r.URL.Path),
+				requestIDAttr.String(requestID),
+			))
+		defer span.End()
+		if username := vars["username"]; username != "" {
+			span.SetAttributes(usernameAttr.String(username))
+		}
+
+		tc := newTraceContext(requestID, span.SpanContext(), parent)
+		w.Header().Set(requestIDHeader, tc.RequestID)
+		w.Header().Set(traceparentHeader, tc.Traceparent())
+		tw := &traceWriter{ResponseWriter: w, trace: tc, statusCode: http.StatusOK}
+
+		next.ServeHTTP(tw, r.WithContext(context.WithValue(withTraceContext(ctx, tc), routeContextKey, template)))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(tw.statusCode))
+		if tw.statusCode >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(tw.statusCode))
+		}
+	})
+}
+
+// traceWriter vuelve a fijar las cabeceras de traza al escribir la respuesta,
+// porque los handlers copian las cabeceras del upstream (que puede traer las
+// suyas), y guarda el status para el span
+type traceWriter struct {
+	http.ResponseWriter
+	trace       TraceContext
+	statusCode  int
+	wroteHeader bool
+}
+
+func (tw *traceWriter) WriteHeader(code int) {
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+		tw.statusCode = code
+		tw.Header().Set(requestIDHeader, tw.trace.RequestID)
+		tw.Header().Set(traceparentHeader, tw.trace.Traceparent())
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *traceWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// Unwrap deja que http.ResponseController llegue al Flush del writer original
+func (tw *traceWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
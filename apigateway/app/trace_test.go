@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTracingMiddlewareGeneratesAndEchoesIDs(t *testing.T) {
+	var seen TraceContext
+	g := NewGateway(&Config{})
+	handler := g.tracingMiddleware(mux.NewRouter(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = traceFromContext(r.Context())
+		// El upstream devuelve sus propios ids; el gateway debe mandar los suyos
+		w.Header().Add(requestIDHeader, "upstream-id")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	if seen.RequestID == "" || len(seen.TraceID) != 32 || len(seen.SpanID) != 16 || seen.ParentID != "" {
+		t.Fatalf("generated trace context = %+v", seen)
+	}
+	if got := rec.Header().Values(requestIDHeader); len(got) != 1 || got[0] != seen.RequestID {
+		t.Errorf("X-Request-ID = %v, want [%s]", got, seen.RequestID)
+	}
+	if got := rec.Header().Get(traceparentHeader); got != seen.Traceparent() {
+		t.Errorf("traceparent = %q, want %q", got, seen.Traceparent())
+	}
+
+	// Ids válidos del cliente se conservan; uno inválido se reemplaza
+	req := httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set(requestIDHeader, "client-123")
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if seen.RequestID != "client-123" || seen.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		seen.ParentID != "00f067aa0ba902b7" || seen.SpanID == seen.ParentID || seen.Flags != "00" {
+		t.Errorf("incoming ids not honored: %+v", seen)
+	}
+
+	// Ids inválidos se reemplazan y el gateway empieza una traza nueva
+	for _, traceparent := range []string{
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01",
+	} {
+		req = httptest.NewRequest("GET", "/health", nil)
+		req.Header.Set(requestIDHeader, "bad id\n"+strings.Repeat("x", 200))
+		req.Header.Set(traceparentHeader, traceparent)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if strings.Contains(seen.RequestID, "bad") {
+			t.Errorf("invalid request id kept: %q", seen.RequestID)
+		}
+		if seen.ParentID != "" || seen.TraceID == "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("invalid traceparent %q honored: %+v", traceparent, seen)
+		}
+	}
+}
+
+func TestTracePropagationToUpstreams(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string]http.Header{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received[r.URL.Path] = r.Header.Clone()
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/accounts/bob":
+			json.NewEncoder(w).Encode(map[string]interface{}{"user": map[string]interface{}{"username": "bob"}})
+		case "/profiles/bob":
+			json.NewEncoder(w).Encode(map[string]interface{}{"bio": "hi"})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer upstream.Close()
+
+	compositions, err := LoadCompositions("../config/compositions.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGateway(&Config{JWTSecret: testSecret, AuthServiceURL: upstream.URL, ProfileServiceURL: upstream.URL, UserDeletedRoutingKey: "user.deleted"})
+	g.compositions = compositions
+	g.outbox = NewOutboxDispatcher(openTestOutbox(t), &flakyPublisher{}, time.Hour, 3)
+	router := g.setupRoutes()
+	handler := g.tracingMiddleware(router, router)
+	token := signTestToken(t, testSecret, map[string]interface{}{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest("GET", "/api/v1/users/bob/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(requestIDHeader, "req-1")
+	req.Header.Set(traceparentHeader, incoming)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	gatewaySpan := strings.Split(rec.Header().Get(traceparentHeader), "-")[2]
+	spans := map[string]bool{}
+	for _, path := range []string{"/accounts/bob", "/profiles/bob"} {
+		header := received[path]
+		if header.Get(requestIDHeader) != "req-1" {
+			t.Errorf("%s got X-Request-ID %q", path, header.Get(requestIDHeader))
+		}
+		parts := strings.Split(header.Get(traceparentHeader), "-")
+		if len(parts) != 4 || parts[1] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Fatalf("%s got traceparent %q", path, header.Get(traceparentHeader))
+		}
+		spanID := parts[2]
+		if spanID == gatewaySpan || spanID == "00f067aa0ba902b7" {
+			t.Errorf("%s reused span %s instead of a child span", path, spanID)
+		}
+		spans[spanID] = true
+	}
+	if len(spans) != 2 {
+		t.Errorf("fan-out calls share a span id: %v", spans)
+	}
+
+	// El evento de eliminación lleva los mismos ids
+	req = httptest.NewRequest("DELETE", "/api/v1/users/bob", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(requestIDHeader, "req-2")
+	req.Header.Set(traceparentHeader, incoming)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	records, err := g.outbox.store.List("")
+	if err != nil || len(records) != 1 {
+		t.Fatalf("outbox = %v, %v", records, err)
+	}
+	meta := records[0].Event.Meta
+	if meta["requestId"] != "req-2" || !strings.Contains(meta["traceparent"].(string), "4bf92f3577b34da6a3ce929d0e0e4736") {
+		t.Errorf("event meta = %v", meta)
+	}
+	if headers := eventHeaders(context.Background(), records[0].Event); headers["traceparent"] != meta["traceparent"] {
+		t.Errorf("AMQP headers = %v", headers)
+	}
+}
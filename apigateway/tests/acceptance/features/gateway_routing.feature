@@ -52,8 +52,9 @@ Característica: Enrutamiento de API Gateway
     Y la respuesta debe tener estado 200 o 401
 
   Escenario: CORS headers están presentes
-    Cuando hago una solicitud GET a "/health"
-    Entonces el header Access-Control-Allow-Origin debe existir
+    Cuando hago un preflight CORS GET a "/health" desde "http://localhost:8888"
+    Entonces la respuesta debe tener estado 204
+    Y el header Access-Control-Allow-Origin debe existir
     Y el header Access-Control-Allow-Methods debe incluir GET
 
   Escenario: Middleware de logging está activo
@@ -157,6 +157,14 @@ func hagoUnaSolicitudPUTAConDatos(endpoint string, dataTable *godog.Table) error
 	return makeRequest("PUT", endpoint, body)
 }
 
+func hagoUnPreflightCORSADesde(method, endpoint, origin string) error {
+	apiContext.customHeaders["Origin"] = origin
+	apiContext.customHeaders["Access-Control-Request-Method"] = method
+	defer delete(apiContext.customHeaders, "Origin")
+	defer delete(apiContext.customHeaders, "Access-Control-Request-Method")
+	return makeRequest("OPTIONS", endpoint, nil)
+}
+
 // ============ VALIDACIONES DE RESPUESTA ============
 
 func laRespuestaDebeTenerEstado(expectedStatus int) error {
@@ -445,6 +453,7 @@ func InitializeScenario(ctx *godog.ScenarioContext, apiCtx *APIGatewayContext) {
 	ctx.Step(`^hago una solicitud POST a "([^"]*)" con datos:$`, hagoUnaSolicitudPOSTAConDatos)
 	ctx.Step(`^hago una solicitud PATCH a "([^"]*)" con datos:$`, hagoUnaSolicitudPATCHAConDatos)
 	ctx.Step(`^hago una solicitud PUT a "([^"]*)" con datos:$`, hagoUnaSolicitudPUTAConDatos)
+	ctx.Step(`^hago un preflight CORS (\w+) a "([^"]*)" desde "([^"]*)"$`, hagoUnPreflightCORSADesde)
 	
 	ctx.Step(`^incluyo token válido$`, incluyoTokenVlido)
 	ctx.Step(`^no incluyo token$`, noIncluyoToken)
@@ -41,6 +41,7 @@ export USER_ROUTING_KEY="${USER_ROUTING_KEY:-user.*}"
 export PASSWORD_ROUTING_KEY="${PASSWORD_ROUTING_KEY:-password.*}"
 export SEND_EMAIL_ROUTING_KEY="${SEND_EMAIL_ROUTING_KEY:-send.email}"
 export SEND_SMS_ROUTING_KEY="${SEND_SMS_ROUTING_KEY:-send.sms}"
+export SECURITY_ROUTING_KEY="${SECURITY_ROUTING_KEY:-security.*}"
 
 log "Generando definitions.json desde template"
 envsubst < /etc/rabbitmq/definitions.template.json > /etc/rabbitmq/definitions.json